@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// defaultAgentSocketName is the file name of the agent socket within the data directory
+const defaultAgentSocketName = "agent.sock"
+
+// agentTokenPrefix is the prefix of the keystore entry of an address
+// that's held by the agent, the rest is the address
+const agentTokenPrefix = "agent:"
+
+// agentRequest is sent to the agent, one per connection
+type agentRequest struct {
+	Op         string `json:"op"`
+	WIF        string `json:"wif,omitempty"`
+	Confirm    bool   `json:"confirm,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+	Addr       string `json:"addr,omitempty"`
+	Check      string `json:"check,omitempty"`
+	Hash       string `json:"hash,omitempty"`
+	TS         string `json:"ts,omitempty"`
+	Content    []byte `json:"content,omitempty"`
+}
+
+// agentResponse is sent back from the agent
+type agentResponse struct {
+	Err       string           `json:"err,omitempty"`
+	Keys      []agentKeyInfo   `json:"keys,omitempty"`
+	Hash      string           `json:"hash,omitempty"`
+	OK        bool             `json:"ok,omitempty"`
+	Message   []byte           `json:"message,omitempty"`
+	Signature contentSignature `json:"signature,omitempty"`
+}
+
+// agentKeyInfo is what the agent shares about a key, never the key itself
+type agentKeyInfo struct {
+	Addr       string `json:"addr"`
+	Currency   string `json:"currency"`
+	SharedFrom string `json:"shared-from"`
+	Confirm    bool   `json:"confirm"`
+}
+
+// agentClient talks to a pubkemail agent over its Unix socket
+type agentClient struct {
+	path string
+}
+
+// defaultAgentSocket returns the socket from PUBKEMAIL_AGENT
+// or the default socket in the default data directory
+func defaultAgentSocket() string {
+	if v := os.Getenv(envPrefix + "AGENT"); v != "" {
+		return v
+	}
+	return filepath.Join(defaultDataDir(), defaultAgentSocketName)
+}
+
+// call sends the request to the agent and returns its response,
+// an error from the agent is returned as an error
+func (a *agentClient) call(req agentRequest) (agentResponse, error) {
+	var resp agentResponse
+
+	conn, err := net.DialTimeout("unix", a.path, 5*time.Second)
+	if err != nil {
+		return resp, fmt.Errorf("agent dial: %v", err)
+	}
+	defer conn.Close()
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, fmt.Errorf("agent send: %v", err)
+	}
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("agent receive: %v", err)
+	}
+	if resp.Err != "" {
+		return resp, errors.New(resp.Err)
+	}
+	return resp, nil
+}
+
+// list returns the keys the agent holds
+func (a *agentClient) list() ([]agentKeyInfo, error) {
+	resp, err := a.call(agentRequest{Op: "list"})
+	return resp.Keys, err
+}
+
+// check asks the agent to check a meta link for the address, see checkMetaLink
+func (a *agentClient) check(addr, id, conHash, timestamp string) (string, bool, error) {
+	resp, err := a.call(agentRequest{Op: "check", Addr: addr, Check: id, Hash: conHash, TS: timestamp})
+	return resp.Hash, resp.OK, err
+}
+
+// decrypt asks the agent to decrypt the content for the address, the
+// agent also checks the signature since it has the server key
+func (a *agentClient) decrypt(addr string, content []byte, ts time.Time) ([]byte, contentSignature, error) {
+	resp, err := a.call(agentRequest{Op: "decrypt", Addr: addr, Content: content, TS: strconv.FormatInt(ts.UnixNano(), 10)})
+	return resp.Message, resp.Signature, err
+}
+
+// agentWIF returns the WIF of an address the agent holds, it only has
+// what's needed to watch the address and the agent does the rest
+func agentWIF(a *agentClient, info agentKeyInfo) WIF {
+	return WIF{
+		wif:        agentTokenPrefix + info.Addr,
+		addr:       info.Addr,
+		currency:   info.Currency,
+		sharedFrom: info.SharedFrom,
+		agent:      a,
+	}
+}
+
+// agentAddrWIF returns the WIF of an address the agent holds from the
+// address alone, so the address is watched even when the agent isn't
+// running yet. The rest is filled in when the agent keys are listed.
+func (c *common) agentAddrWIF(addr string) (WIF, error) {
+	if c.agent == nil {
+		return WIF{}, fmt.Errorf("%s is held by an agent, use --agent to connect to it", addr)
+	}
+
+	currency, err := addrCurrency(addr)
+	if err != nil {
+		return WIF{}, fmt.Errorf("agent %v", err)
+	}
+	return agentWIF(c.agent, agentKeyInfo{Addr: addr, Currency: currency}), nil
+}
+
+// loadAgentKeys watches any address the agent holds that isn't watched
+// yet, it's called from the feed reader so keys added to the agent are
+// picked up while the client is running
+func (c *common) loadAgentKeys() {
+	if c.agent == nil {
+		return
+	}
+
+	keys, err := c.agent.list()
+	if err != nil {
+		log.Warnf("agent keys: %v", err)
+		return
+	}
+
+	for _, info := range keys {
+		if _, ok := c.addrDataOf(info.Addr); !ok {
+			c.addAddr(agentWIF(c.agent, info), "")
+		}
+	}
+}
+
+// agentCommand is the agent subcommand. Without a verb it runs the agent,
+// otherwise add, remove, list, lock and unlock talk to the running agent.
+func agentCommand(args []string) {
+	verb := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		verb, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	socket := fs.StringP("socket", "s", defaultAgentSocket(), "the Unix socket of the agent, PUBKEMAIL_AGENT can also be used")
+	dataDir := fs.StringP("data-dir", "d", defaultDataDir(), "the directory the agent pins the pubkemail shared public keys in")
+	serverKeysPath := fs.StringP("server-keys", "", "", "a PEM file or directory of pubkemail shared public keys, so they never need to be fetched")
+	apiShared := fs.StringP("api-shared", "", apiURL.shared, "the base URL of the pubkemail shared public keys")
+	wifStrs := fs.StringSliceP("wif", "w", nil, "a WIF to add to the agent, can be repeated")
+	confirm := fs.BoolP("confirm", "", false, "ask on the agent's terminal before each message for the added WIFs is decrypted")
+	addr := fs.StringP("addr", "", "", "the address to remove from the agent")
+	fs.Parse(args)
+
+	a := &agentClient{path: *socket}
+
+	var err error
+	switch verb {
+	case "":
+		apiURL.shared = strings.TrimSuffix(*apiShared, "/")
+		if err = openServerKeys(filepath.Join(*dataDir, defaultServerKeysName), "", *serverKeysPath); err == nil {
+			err = agentServe(*socket)
+		}
+	case "add":
+		for _, wifStr := range *wifStrs {
+			var resp agentResponse
+			if resp, err = a.call(agentRequest{Op: "add", WIF: wifStr, Confirm: *confirm}); err != nil {
+				break
+			}
+			for _, info := range resp.Keys {
+				fmt.Printf("added %s %s\n", info.Currency, info.Addr)
+			}
+		}
+	case "remove":
+		_, err = a.call(agentRequest{Op: "remove", Addr: *addr})
+	case "list":
+		var keys []agentKeyInfo
+		if keys, err = a.list(); err == nil {
+			for _, info := range keys {
+				confirm := ""
+				if info.Confirm {
+					confirm = " (confirm)"
+				}
+				fmt.Printf("%s %s server key %s%s\n", info.Currency, info.Addr, info.SharedFrom, confirm)
+			}
+		}
+	case "lock", "unlock":
+		fmt.Printf("Agent %s passphrase: ", verb)
+		pass, perr := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err = perr; err == nil {
+			_, err = a.call(agentRequest{Op: verb, Passphrase: string(pass)})
+		}
+	default:
+		err = fmt.Errorf("unknown agent command %q, use add, remove, list, lock or unlock", verb)
+	}
+
+	if err != nil {
+		fmt.Printf("agent: %v\n", err)
+		os.Exit(1)
+	}
+}
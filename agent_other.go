@@ -0,0 +1,15 @@
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// agentServe is not supported here, there's no Unix socket or locked memory
+// to hold the keys in. Clients can still use an agent on another machine
+// through a forwarded socket.
+func agentServe(path string) error {
+	return fmt.Errorf("the agent is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,357 @@
+// +build linux darwin freebsd netbsd openbsd
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// agentKey is a WIF held by the agent, the private and shared keys
+// are in locked memory so they are never swapped to disk
+type agentKey struct {
+	wif     WIF
+	confirm bool
+}
+
+// agentServer holds the WIFs and answers the requests from clients
+type agentServer struct {
+	m        sync.Mutex
+	keys     map[string]*agentKey
+	lockSalt []byte
+	lockHash []byte // set while the agent is locked
+
+	confirmM sync.Mutex // one confirmation prompt at a time
+}
+
+// lockedBytes returns a copy of b in memory that is locked so it can't
+// be swapped to disk, it's released with freeLocked
+func lockedBytes(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	mem, err := syscall.Mmap(-1, 0, len(b), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, fmt.Errorf("agent mmap: %v", err)
+	}
+	if err = syscall.Mlock(mem); err != nil {
+		syscall.Munmap(mem)
+		return nil, fmt.Errorf("agent mlock: %v", err)
+	}
+	copy(mem, b)
+	return mem, nil
+}
+
+// freeLocked zeros and releases memory from lockedBytes
+func freeLocked(mem []byte) {
+	if len(mem) == 0 {
+		return
+	}
+	for i := range mem {
+		mem[i] = 0
+	}
+	syscall.Munlock(mem)
+	syscall.Munmap(mem)
+}
+
+// agentServe runs the agent on the Unix socket at path until it's interrupted,
+// the keys are wiped when it stops
+func agentServe(path string) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return fmt.Errorf("an agent is already running on %s", path)
+		}
+		os.Remove(path) // left behind by an agent that didn't stop cleanly
+	}
+
+	// only the user can connect to the socket
+	mask := syscall.Umask(0177)
+	ln, err := net.Listen("unix", path)
+	syscall.Umask(mask)
+	if err != nil {
+		return fmt.Errorf("agent listen: %v", err)
+	}
+
+	s := &agentServer{keys: make(map[string]*agentKey)}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		ln.Close()
+	}()
+
+	fmt.Printf("pubkemail agent listening on %s\n", path)
+	fmt.Printf("use --agent %s or %sAGENT=%s to connect the client\n", path, envPrefix, path)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			break
+		}
+		go s.serveConn(conn)
+	}
+
+	s.m.Lock()
+	for addr := range s.keys {
+		s.remove(addr)
+	}
+	s.m.Unlock()
+	os.Remove(path)
+	return nil
+}
+
+// serveConn answers the one request sent on the connection
+func (s *agentServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	var req agentRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	resp, err := s.handle(req)
+	if err != nil {
+		resp = agentResponse{Err: err.Error()}
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// handle does what the request asks, while the agent is locked
+// everything but unlock is refused
+func (s *agentServer) handle(req agentRequest) (resp agentResponse, err error) {
+	s.m.Lock()
+	locked := s.lockHash != nil
+	s.m.Unlock()
+
+	if locked && req.Op != "unlock" {
+		return resp, fmt.Errorf("the agent is locked")
+	}
+
+	switch req.Op {
+	case "add":
+		return s.add(req)
+	case "remove":
+		s.m.Lock()
+		defer s.m.Unlock()
+		if _, ok := s.keys[req.Addr]; !ok {
+			return resp, fmt.Errorf("no key for %s", req.Addr)
+		}
+		s.remove(req.Addr)
+	case "list":
+		resp.Keys = s.list()
+	case "check":
+		s.m.Lock()
+		defer s.m.Unlock()
+		key, ok := s.keys[req.Addr]
+		if !ok {
+			return resp, fmt.Errorf("no key for %s", req.Addr)
+		}
+		resp.Hash, resp.OK = checkMetaLink(key.wif, req.Check, req.Hash, req.TS)
+	case "decrypt":
+		return s.decrypt(req)
+	case "lock":
+		return resp, s.lock(req.Passphrase)
+	case "unlock":
+		return resp, s.unlock(req.Passphrase)
+	default:
+		return resp, fmt.Errorf("unknown request %q", req.Op)
+	}
+	return resp, nil
+}
+
+// add unmarshals the WIF and moves its keys into locked memory
+func (s *agentServer) add(req agentRequest) (resp agentResponse, err error) {
+	wif, err := unmarshalWIF(strings.TrimSpace(req.WIF))
+	if err != nil {
+		return resp, err
+	}
+
+	priKey, err := lockedBytes(wif.priKey)
+	if err != nil {
+		return resp, err
+	}
+	sharedKey, err := lockedBytes(wif.sharedKey)
+	if err != nil {
+		freeLocked(priKey)
+		return resp, err
+	}
+	for i := range wif.priKey {
+		wif.priKey[i] = 0
+	}
+	for i := range wif.sharedKey {
+		wif.sharedKey[i] = 0
+	}
+	wif.wif, wif.priKey, wif.sharedKey = "", priKey, sharedKey
+
+	s.m.Lock()
+	if _, ok := s.keys[wif.addr]; ok {
+		s.remove(wif.addr)
+	}
+	s.keys[wif.addr] = &agentKey{wif: wif, confirm: req.Confirm}
+	s.m.Unlock()
+
+	resp.Keys = []agentKeyInfo{{Addr: wif.addr, Currency: wif.currency, SharedFrom: wif.sharedFrom, Confirm: req.Confirm}}
+	return resp, nil
+}
+
+// remove wipes the key for the address, the lock must be held
+func (s *agentServer) remove(addr string) {
+	key := s.keys[addr]
+	freeLocked(key.wif.priKey)
+	freeLocked(key.wif.sharedKey)
+	delete(s.keys, addr)
+}
+
+// list returns the keys that are held sorted by address
+func (s *agentServer) list() (keys []agentKeyInfo) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, key := range s.keys {
+		keys = append(keys, agentKeyInfo{
+			Addr:       key.wif.addr,
+			Currency:   key.wif.currency,
+			SharedFrom: key.wif.sharedFrom,
+			Confirm:    key.confirm,
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Addr < keys[j].Addr })
+	return keys
+}
+
+// key returns the key for the address
+func (s *agentServer) key(addr string) (*agentKey, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	key, ok := s.keys[addr]
+	if !ok {
+		return nil, fmt.Errorf("no key for %s", addr)
+	}
+	return key, nil
+}
+
+// decrypt decrypts the content for the address, keys that were
+// added with --confirm ask on the agent's terminal first
+func (s *agentServer) decrypt(req agentRequest) (resp agentResponse, err error) {
+	key, err := s.key(req.Addr)
+	if err != nil {
+		return resp, err
+	}
+
+	nsec, err := strconv.ParseInt(req.TS, 10, 64)
+	if err != nil {
+		return resp, fmt.Errorf("decrypt timestamp: %v", err)
+	}
+
+	if key.confirm {
+		if err = s.confirm(fmt.Sprintf("Decrypt a message for %s?", req.Addr)); err != nil {
+			return resp, err
+		}
+	}
+
+	// the key may have been removed while waiting on the prompt, it's
+	// copied so that the lock isn't held while decrypting and a remove
+	// can't release the memory out from under it
+	s.m.Lock()
+	if s.keys[req.Addr] != key {
+		s.m.Unlock()
+		return resp, fmt.Errorf("no key for %s", req.Addr)
+	}
+	priKey, err := lockedBytes(key.wif.priKey)
+	serverKey := key.wif.serverKey
+	s.m.Unlock()
+	if err != nil {
+		return resp, err
+	}
+	defer freeLocked(priKey)
+
+	resp.Message, resp.Signature, err = decryptContent(priKey, serverKey, bytes.NewReader(req.Content), time.Unix(0, nsec))
+	return resp, err
+}
+
+// confirm asks the question on the terminal the agent was started from,
+// anything but y is a no
+func (s *agentServer) confirm(question string) error {
+	s.confirmM.Lock()
+	defer s.confirmM.Unlock()
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("confirm: %v", err)
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		return fmt.Errorf("refused on the agent's terminal")
+	}
+	return nil
+}
+
+// lock locks the agent with the passphrase, only a hash of it is kept
+func (s *agentServer) lock(passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("lock: a passphrase is needed")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("lock salt: %v", err)
+	}
+	hash, err := scrypt.Key([]byte(passphrase), salt, keystoreScryptN, keystoreScryptR, keystoreScryptP, 32)
+	if err != nil {
+		return fmt.Errorf("lock scrypt: %v", err)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.lockHash != nil {
+		return fmt.Errorf("the agent is locked")
+	}
+	s.lockSalt, s.lockHash = salt, hash
+	return nil
+}
+
+// unlock unlocks the agent if the passphrase matches the one it was locked with
+func (s *agentServer) unlock(passphrase string) error {
+	s.m.Lock()
+	salt, want := s.lockSalt, s.lockHash
+	s.m.Unlock()
+
+	if want == nil {
+		return fmt.Errorf("the agent is not locked")
+	}
+
+	hash, err := scrypt.Key([]byte(passphrase), salt, keystoreScryptN, keystoreScryptR, keystoreScryptP, 32)
+	if err != nil {
+		return fmt.Errorf("unlock scrypt: %v", err)
+	}
+	if subtle.ConstantTimeCompare(hash, want) != 1 {
+		return fmt.Errorf("unlock: the passphrase is incorrect")
+	}
+
+	s.m.Lock()
+	s.lockSalt, s.lockHash = nil, nil
+	s.m.Unlock()
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/njones/base58"
+	"github.com/tyler-smith/go-bip39"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/text/unicode/norm"
+)
+
+// defaultMnemonicPath is the BIP44 receive path of the first bitcoin
+// account, it's used when a seed phrase is added without a path
+const defaultMnemonicPath = "m/44'/0'/0'/0"
+
+// isMnemonic returns true if the string looks like a BIP39 seed phrase
+func isMnemonic(s string) bool {
+	return len(strings.Fields(s)) >= 12
+}
+
+// isBIP38 returns true if the string looks like a BIP38 encrypted private key
+func isBIP38(s string) bool {
+	return len(s) == 58 && strings.HasPrefix(s, "6P")
+}
+
+// mnemonicXPrv returns the master extended private key of a BIP39 seed
+// phrase, the passphrase is the optional BIP39 passphrase
+func mnemonicXPrv(mnemonic, passphrase string) (string, error) {
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return "", fmt.Errorf("seed phrase: %v", err)
+	}
+
+	master, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		return "", fmt.Errorf("seed phrase master key: %v", err)
+	}
+	return master.String(), nil
+}
+
+// decryptBIP38 decrypts a BIP38 passphrase protected private key and returns
+// it as a WIF. Both the plain and the EC multiplied (paper wallet) forms are
+// supported, the address hash is checked so a wrong passphrase is an error.
+func decryptBIP38(encKey, passphrase string) (string, error) {
+	b, err := base58.BitcoinEncoding.DecodeString(encKey)
+	if err != nil {
+		return "", fmt.Errorf("bip38 decode: %v", err)
+	}
+	if len(b) != 43 {
+		return "", fmt.Errorf("bip38 key is invalid")
+	}
+
+	payload, check := b[:39], b[39:]
+	if sum := doubleSHA256(payload); !bytes.Equal(sum[:4], check) {
+		return "", fmt.Errorf("bip38 checksum is invalid")
+	}
+
+	pass := norm.NFC.Bytes([]byte(passphrase))
+	flag, addrHash := payload[2], payload[3:7]
+	compressed := flag&0x20 != 0
+
+	var priKey []byte
+	switch {
+	case payload[0] == 0x01 && payload[1] == 0x42:
+		priKey, err = decryptBIP38Plain(payload, pass)
+	case payload[0] == 0x01 && payload[1] == 0x43:
+		priKey, err = decryptBIP38ECMult(payload, pass)
+	default:
+		return "", fmt.Errorf("bip38 key type 0x%x is not supported", payload[:2])
+	}
+	if err != nil {
+		return "", err
+	}
+
+	pri, pub := btcec.PrivKeyFromBytes(btcec.S256(), priKey)
+	pubKey := pub.SerializeUncompressed()
+	if compressed {
+		pubKey = pub.SerializeCompressed()
+	}
+
+	addr, err := btcutil.NewAddressPubKey(pubKey, &chaincfg.MainNetParams)
+	if err != nil {
+		return "", fmt.Errorf("bip38 address: %v", err)
+	}
+	if sum := doubleSHA256([]byte(addr.EncodeAddress())); !bytes.Equal(sum[:4], addrHash) {
+		return "", fmt.Errorf("bip38 passphrase is incorrect")
+	}
+
+	wif, err := btcutil.NewWIF(pri, &chaincfg.MainNetParams, compressed)
+	if err != nil {
+		return "", fmt.Errorf("bip38 wif: %v", err)
+	}
+	return wif.String(), nil
+}
+
+// decryptBIP38Plain decrypts a key that was encrypted without EC multiplication
+func decryptBIP38Plain(payload, pass []byte) ([]byte, error) {
+	derived, err := scrypt.Key(pass, payload[3:7], 16384, 8, 8, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bip38 scrypt: %v", err)
+	}
+
+	block, err := aes.NewCipher(derived[32:])
+	if err != nil {
+		return nil, fmt.Errorf("bip38 aes: %v", err)
+	}
+
+	priKey := make([]byte, 32)
+	block.Decrypt(priKey[:16], payload[7:23])
+	block.Decrypt(priKey[16:], payload[23:39])
+	for i := range priKey {
+		priKey[i] ^= derived[i]
+	}
+	return priKey, nil
+}
+
+// decryptBIP38ECMult decrypts a key that was made from an intermediate
+// passphrase code, like the ones printed by paper wallet generators
+func decryptBIP38ECMult(payload, pass []byte) ([]byte, error) {
+	flag, addrHash, ownerEntropy := payload[2], payload[3:7], payload[7:15]
+
+	ownerSalt := ownerEntropy
+	if flag&0x04 != 0 {
+		ownerSalt = ownerEntropy[:4] // the rest is the lot and sequence numbers
+	}
+
+	passFactor, err := scrypt.Key(pass, ownerSalt, 16384, 8, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bip38 scrypt: %v", err)
+	}
+	if flag&0x04 != 0 {
+		sum := doubleSHA256(append(passFactor, ownerEntropy...))
+		passFactor = sum[:]
+	}
+
+	_, passPoint := btcec.PrivKeyFromBytes(btcec.S256(), passFactor)
+	derived, err := scrypt.Key(passPoint.SerializeCompressed(), append(append([]byte{}, addrHash...), ownerEntropy...), 1024, 1, 1, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bip38 scrypt: %v", err)
+	}
+
+	block, err := aes.NewCipher(derived[32:])
+	if err != nil {
+		return nil, fmt.Errorf("bip38 aes: %v", err)
+	}
+
+	part2 := make([]byte, 16)
+	block.Decrypt(part2, payload[23:39])
+	for i := range part2 {
+		part2[i] ^= derived[16+i]
+	}
+
+	part1 := make([]byte, 16)
+	block.Decrypt(part1, append(append([]byte{}, payload[15:23]...), part2[:8]...))
+	for i := range part1 {
+		part1[i] ^= derived[i]
+	}
+
+	factorB := doubleSHA256(append(part1, part2[8:]...))
+
+	n := btcec.S256().N
+	priKey := new(big.Int).Mul(new(big.Int).SetBytes(passFactor), new(big.Int).SetBytes(factorB[:]))
+	priKey.Mod(priKey, n)
+
+	b := priKey.Bytes()
+	return append(make([]byte, 32-len(b)), b...), nil
+}
+
+// doubleSHA256 returns the SHA-256 of the SHA-256 of b
+func doubleSHA256(b []byte) [32]byte {
+	sum := sha256.Sum256(b)
+	return sha256.Sum256(sum[:])
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"html/template"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -25,24 +28,48 @@ const defaultShortWait = 10
 // defaultLongWait is the time to wait after errors or during feed resets
 const defaultLongWait = 65
 
+// defaultShutdownWait is the time in seconds to wait for queued links to be
+// checked during shutdown before anything still running is aborted
+const defaultShutdownWait = 30
+
+// apiURL holds the base URLs of the pubkemail services, they can
+// be pointed at a mirror or at the simulate subcommand
+var apiURL = struct {
+	rss, content, shared string
+}{
+	rss:     "https://rss.pubkemail.com",
+	content: "https://content.pubkemail.com",
+	shared:  "https://shared.pubkemail.com",
+}
+
 // WIF holds everything needed to work with WIFs
 type WIF struct {
-	wif       string
-	addr      string
-	addrComp  string
-	currency  string
-	pubKey    []byte
-	priKey    []byte
-	sharedKey []byte
+	wif        string
+	addr       string // the form the WIF is for
+	addrComp   string
+	addrUncomp string
+	currency   string
+	pubKey     []byte
+	priKey     []byte
+	sharedKey  []byte
+	serverKey  []byte       // the pubkemail key that signs the messages
+	sharedFrom string       // the fingerprint and source of the server key
+	agent      *agentClient // holds the private key when the WIF is in an agent
 }
 
 // AddrDisplay holds data that can be displayed on the
 // user facing web page about an address
 type AddrDisplay struct {
-	WIF    string // truncated
-	Addr   string
-	CurAbv string
-	FwdTo  string
+	WIF           string // truncated
+	Addr          string
+	CurAbv        string
+	FwdTo         string
+	Label         string // a name for the address, the forwarder templates can use it
+	LastDelivered string
+	Group         string // the extended key and path it was derived from
+	SharedKey     string // the server key the shared key was derived from
+	Waiting       int    // mail found for a watch-only address
+	Quarantined   int    // mail that failed verification and wasn't forwarded
 }
 
 // FwdDisplay holds data that can be displayed on the user facing
@@ -54,10 +81,10 @@ type FwdDisplay struct {
 
 // addrData holds data that can be used to work with addresses
 type addrData struct {
-	isFwd         bool
-	feedLinksDone *sync.WaitGroup
-	feedLinks     chan string
-	wif           WIF
+	isFwd     bool
+	feedLinks chan string
+	wif       WIF
+	stop      context.CancelFunc // stops the checker, nil when there isn't one
 }
 
 // fwdData holds data that can be used to work with sending emails
@@ -98,6 +125,14 @@ type common struct {
 
 		Fwd struct {
 			Display map[string]FwdDisplay
+			Presets map[string]string // the titles of the provider presets
+		}
+		Keygen struct {
+			Networks []string
+			Display  []KeygenDisplay // cleared once shown
+		}
+		Outbox struct {
+			Display []OutboxDisplay // refreshed when the page is shown
 		}
 		Addr struct {
 			Display map[string]AddrDisplay
@@ -105,19 +140,73 @@ type common struct {
 		}
 		Const struct {
 			WIFStr  string
+			WIFPass string
+			HDPath  string
+			HDGap   string
 			FwdName string
 			FwdJSON string
 
-			Submit        string
-			SubmitAddWIF  string
-			SubmitFwd     string
-			SubmitFwdTest string
-			SubmitFwdTo   string
+			FwdPreset       string
+			FwdPresetKey    string
+			FwdPresetSecret string
+			FwdPresetDomain string
+			FwdPresetRegion string
+			FwdPresetTo     string
+
+			KeygenNet   string
+			KeygenCount string
+
+			OutboxHash string
+			AddrLabel  string // the prefix of the label field of an address
+
+			Submit              string
+			SubmitAddWIF        string
+			SubmitFwd           string
+			SubmitFwdTest       string
+			SubmitFwdTo         string
+			SubmitKeygen        string
+			SubmitOutboxRetry   string
+			SubmitOutboxDiscard string
 		}
 	}
 
+	// dataM guards the forwarder and address maps, their displays and the
+	// labels, they're changed by the feed reader as well as the web handlers
+	dataM        sync.RWMutex
 	fwdDataMap   map[string]fwdData
 	addrsDataMap map[string]addrData
+	labels       map[string]string // keyed by address, so it's kept for addresses that aren't derived yet
+	hd           commonHD
+
+	// ctx is done when the client is stopping, no new links are read
+	// but the links that are queued are still checked. The work context
+	// is for the downloads and sends of those links, it's only aborted
+	// when shutdown takes too long.
+	ctx    context.Context
+	cancel context.CancelFunc
+	work   context.Context
+	abort  context.CancelFunc
+
+	dataDir        string
+	keyManifest    string
+	serverKeysPath string
+	pendingPath    string // messages exported from a watch-only client
+	agent          *agentClient
+	store          *keystore
+	ledger         *ledger
+	outbox         *outbox
+
+	// daemon holds the settings for running without the terminal view
+	daemon struct {
+		headless       bool
+		passphraseFile string
+		configPath     string
+	}
+
+	// provision holds the forwarders and addresses from the config, they
+	// are added once everything in the data directory has been opened and
+	// again after the keystore is unlocked so the config wins
+	provision []commonOptFunc
 
 	web  commonWeb
 	term commonTerm
@@ -157,27 +246,451 @@ func newCommon(opts ...commonOptFunc) *common {
 		NewMail: make(map[string]int),
 	}
 	c.Data.Fwd.Display = make(map[string]FwdDisplay)
+	c.Data.Fwd.Presets = fwdPresetTitles()
 
-	c.Data.Const.WIFStr = "wif-str"
+	c.Data.Const.WIFStr = formWIFStr
+	c.Data.Const.WIFPass = "wif-pass"
+	c.Data.Const.HDPath = "hd-path"
+	c.Data.Const.HDGap = "hd-gap"
 	c.Data.Const.FwdName = "fwd-name"
 	c.Data.Const.FwdJSON = "fwd-json"
-	c.Data.Const.Submit = "sub"
-	c.Data.Const.SubmitAddWIF = "sub-add-wif"
+	c.Data.Const.FwdPreset = "fwd-preset"
+	c.Data.Const.FwdPresetKey = "fwd-preset-key"
+	c.Data.Const.FwdPresetSecret = "fwd-preset-secret"
+	c.Data.Const.FwdPresetDomain = "fwd-preset-domain"
+	c.Data.Const.FwdPresetRegion = "fwd-preset-region"
+	c.Data.Const.FwdPresetTo = "fwd-preset-to"
+	c.Data.Const.KeygenNet = "keygen-net"
+	c.Data.Const.KeygenCount = "keygen-count"
+	c.Data.Const.OutboxHash = "outbox-hash"
+	c.Data.Const.AddrLabel = "addr-label:"
+	c.Data.Const.Submit = formSubmit
+	c.Data.Const.SubmitAddWIF = formSubmitAddWIF
 	c.Data.Const.SubmitFwd = "sub-fwd"
 	c.Data.Const.SubmitFwdTest = "sub-fwd-test"
 	c.Data.Const.SubmitFwdTo = "sub-fwd-to"
+	c.Data.Const.SubmitKeygen = "sub-keygen"
+	c.Data.Const.SubmitOutboxRetry = "sub-outbox-retry"
+	c.Data.Const.SubmitOutboxDiscard = "sub-outbox-discard"
+
+	c.Data.Keygen.Networks = keygenNetworkNames()
 
 	c.addrsDataMap = make(map[string]addrData)
 	c.fwdDataMap = make(map[string]fwdData)
+	c.labels = make(map[string]string)
+	c.hd.groups = make(map[string]*hdGroup)
+	c.hd.addrs = make(map[string]hdIndex)
+	c.hd.grow = make(map[string]bool)
+
+	c.dataDir = defaultDataDir()
+
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.work, c.abort = context.WithCancel(context.Background())
 
 	for _, opt := range opts {
 		opt(c)
 	}
+	c.web.server = c.newWebServer()
 
 	err := c.loadTemplates()
 	if err != nil {
 		panic(err)
 	}
 
+	c.store, err = openKeystore(filepath.Join(c.dataDir, defaultKeystoreName))
+	if err != nil {
+		panic(err)
+	}
+
+	c.ledger, err = openLedger(filepath.Join(c.dataDir, defaultLedgerName))
+	if err != nil {
+		panic(err)
+	}
+
+	c.outbox, err = openOutbox(outboxPath(c.dataDir))
+	if err != nil {
+		panic(err)
+	}
+
+	err = openServerKeys(filepath.Join(c.dataDir, defaultServerKeysName), c.keyManifest, c.serverKeysPath)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, fn := range c.provision {
+		fn(c)
+	}
+
 	return c
 }
+
+// addAddr adds a WIF to the addresses that are watched, checking
+// starts right away if there is a forwarder to send the mail to
+func (c *common) addAddr(wif WIF, fwdTo string) {
+	fwdTo = fwdKey(fwdTo)
+	c.dataM.Lock()
+	defer c.dataM.Unlock()
+
+	// the same address can come from the config and the keystore,
+	// so only the forwarder is updated if it's already watched
+	if data, ok := c.addrsDataMap[wif.addr]; ok {
+		if fwdTo == "" {
+			return
+		}
+		display := c.Data.Addr.Display[wif.addr]
+		display.FwdTo = fwdTo
+		c.Data.Addr.Display[wif.addr] = display
+
+		data.isFwd = true
+		if data.stop == nil {
+			data.stop = c.startAddrChecker(wif.addr)
+		}
+		c.addrsDataMap[wif.addr] = data
+		return
+	}
+
+	c.Data.Addr.m.Lock()
+	c.Data.Addr.NewMail[wif.addr] = 0
+	c.Data.Addr.m.Unlock()
+	c.Data.Addr.Display[wif.addr] = AddrDisplay{
+		Addr:          wif.addr,
+		WIF:           wif.wif,
+		CurAbv:        wif.currency,
+		FwdTo:         fwdTo,
+		Label:         c.labels[wif.addr],
+		LastDelivered: fmtWatermark(c.ledger.watermark(wif.addr)),
+		SharedKey:     wif.sharedFrom,
+		Waiting:       c.ledger.waiting(wif.addr),
+		Quarantined:   c.ledger.quarantined(wif.addr),
+	}
+
+	data := addrData{
+		isFwd:     len(fwdTo) > 0 || wif.isWatchOnly(),
+		feedLinks: make(chan string, defaultFeedLinksChanLen),
+		wif:       wif,
+	}
+	if data.isFwd {
+		data.stop = c.startAddrChecker(wif.addr)
+	}
+	c.addrsDataMap[wif.addr] = data
+	c.updateViewBottom(addrDataMapToString(c.addrsDataMap))
+}
+
+// addSecret adds a key in any of the formats that are accepted, a WIF, a BIP38
+// encrypted key, an xprv or a BIP39 seed phrase. The passphrase decrypts a BIP38
+// key or is the BIP39 passphrase, the path and gap are only used for an xprv or
+// seed phrase.
+func (c *common) addSecret(secret, passphrase, path string, gap int, fwdTo string) error {
+	secret = strings.TrimSpace(secret)
+	switch {
+	case isMnemonic(secret):
+		xprv, err := mnemonicXPrv(secret, passphrase)
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			path = defaultMnemonicPath
+		}
+		return c.addHDGroup(xprv, path, gap, 0, fwdTo)
+	case isXPrv(secret):
+		return c.addHDGroup(secret, path, gap, 0, fwdTo)
+	case strings.HasPrefix(secret, agentTokenPrefix):
+		wif, err := c.agentAddrWIF(strings.TrimPrefix(secret, agentTokenPrefix))
+		if err != nil {
+			return err
+		}
+		c.addAddr(wif, fwdTo)
+		return nil
+	case isWatchOnlyKey(secret):
+		wif, err := decodeWatchOnly(secret)
+		if err != nil {
+			return err
+		}
+		c.addAddr(wif, "") // there's nothing to forward without the WIF
+		return nil
+	case isBIP38(secret):
+		wifStr, err := decryptBIP38(secret, passphrase)
+		if err != nil {
+			return err
+		}
+		secret = wifStr
+	}
+
+	wif, err := unmarshalWIF(secret)
+	if err != nil {
+		return err
+	}
+	c.addAddr(wif, fwdTo)
+	return nil
+}
+
+// addrDataOf returns the data of a watched address
+func (c *common) addrDataOf(addr string) (addrData, bool) {
+	c.dataM.RLock()
+	defer c.dataM.RUnlock()
+	data, ok := c.addrsDataMap[addr]
+	return data, ok
+}
+
+// addrDisplayOf returns the display of a watched address
+func (c *common) addrDisplayOf(addr string) (AddrDisplay, bool) {
+	c.dataM.RLock()
+	defer c.dataM.RUnlock()
+	display, ok := c.Data.Addr.Display[addr]
+	return display, ok
+}
+
+// updateAddrDisplay changes the display of a watched address, nothing
+// is done if the address isn't watched
+func (c *common) updateAddrDisplay(addr string, fn func(*AddrDisplay)) {
+	c.dataM.Lock()
+	defer c.dataM.Unlock()
+	if display, ok := c.Data.Addr.Display[addr]; ok {
+		fn(&display)
+		c.Data.Addr.Display[addr] = display
+	}
+}
+
+// startAddrChecker starts checking the feed links for an address, there
+// is only ever one per address so the func it returns is kept to stop it
+func (c *common) startAddrChecker(addr string) context.CancelFunc {
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.term.checkers.Add(1)
+	go c.termAddrChecker(ctx, addr)
+	return cancel
+}
+
+// afterDate returns the time that mail for the address must be after to be
+// forwarded. The --after flag wins, otherwise it's the last delivered mail
+// in the ledger.
+func (c *common) afterDate(addr string) time.Time {
+	if !c.term.check.afterDate.IsZero() {
+		return c.term.check.afterDate
+	}
+	return c.ledger.watermark(addr)
+}
+
+// fwdKey returns the name a forwarder is keyed by, it's used for the names of
+// the forwarders and for the forwarder an address is assigned to so that the
+// config, environment and web interface names all match
+func fwdKey(name string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "-", "_", "-").Replace(strings.TrimSpace(name)))
+}
+
+// addFwd decodes the forwarding JSON and adds it under the name
+// supplied, it returns the kind of forwarder that was added
+func (c *common) addFwd(fwdNameText, fwdJSONText string) (kind string, err error) {
+	fwdEmail, kind, err := fwdEmailFromJSON(fwdJSONText)
+	if err != nil {
+		return kind, err
+	}
+
+	name := fwdKey(fwdNameText)
+	c.dataM.Lock()
+	c.fwdDataMap[name] = fwdData{fwdEmail: fwdEmail}
+	c.Data.Fwd.Display[name] = FwdDisplay{
+		Name: fwdNameText,
+		JSON: fwdJSONText,
+	}
+	c.dataM.Unlock()
+	return kind, nil
+}
+
+// fwdOf returns the forwarder with the name
+func (c *common) fwdOf(name string) (fwdData, bool) {
+	c.dataM.RLock()
+	defer c.dataM.RUnlock()
+	fwd, ok := c.fwdDataMap[fwdKey(name)]
+	return fwd, ok
+}
+
+// anyFwdName returns the name of one of the forwarders, or
+// an empty string if there aren't any
+func (c *common) anyFwdName() string {
+	c.dataM.RLock()
+	defer c.dataM.RUnlock()
+	for name := range c.fwdDataMap {
+		return name
+	}
+	return ""
+}
+
+// delFwd removes a forwarder, any address that was using it will
+// no longer be forwarded
+func (c *common) delFwd(fwdNameText string) {
+	name := fwdKey(fwdNameText)
+	c.dataM.Lock()
+	defer c.dataM.Unlock()
+	for addr, display := range c.Data.Addr.Display {
+		if display.FwdTo == name {
+			display.FwdTo = ""
+			if data, ok := c.addrsDataMap[addr]; ok {
+				data.isFwd = data.wif.isWatchOnly()
+				if !data.isFwd && data.stop != nil {
+					data.stop() // the links it has are still checked
+					data.stop = nil
+				}
+				c.addrsDataMap[addr] = data
+			}
+			c.Data.Addr.Display[addr] = display
+		}
+	}
+	delete(c.fwdDataMap, name)
+	delete(c.Data.Fwd.Display, name)
+}
+
+// loadKeystore unlocks the keystore with the passphrase and adds
+// all of the forwarders and addresses that were saved, anything
+// from the config replaces what was saved
+func (c *common) loadKeystore(passphrase []byte) error {
+	data, err := c.store.unlock(passphrase)
+	if err != nil {
+		return err
+	}
+
+	for _, fwd := range data.Fwds {
+		if _, err := c.addFwd(fwd.Name, fwd.JSON); err != nil {
+			log.Warnf("keystore forwarder %q: %v", fwd.Name, err)
+		}
+	}
+
+	for _, addr := range data.Addrs {
+		err := c.addSecret(addr.WIF, "", "", 0, addr.FwdTo)
+		log.OnErr(err).Warnf("keystore address: %v", err)
+	}
+
+	for _, group := range data.Groups {
+		err := c.addHDGroup(group.XPrv, group.Path, group.Gap, group.Next, group.FwdTo)
+		log.OnErr(err).Warnf("keystore address group: %v", err)
+	}
+
+	for addr, label := range data.Labels {
+		c.setLabel(addr, label)
+	}
+
+	// the config is applied again so that it wins over the saved copy
+	// of a forwarder or address that it also defines
+	for _, fn := range c.provision {
+		fn(c)
+	}
+
+	// write back anything that was added before the keystore was unlocked
+	c.saveKeystore()
+	return nil
+}
+
+// setLabel names an address, an empty label removes the name
+func (c *common) setLabel(addr, label string) {
+	c.dataM.Lock()
+	defer c.dataM.Unlock()
+
+	label = strings.TrimSpace(label)
+	if label == "" {
+		delete(c.labels, addr)
+	} else {
+		c.labels[addr] = label
+	}
+
+	if display, ok := c.Data.Addr.Display[addr]; ok {
+		display.Label = label
+		c.Data.Addr.Display[addr] = display
+	}
+}
+
+// saveKeystore writes the current forwarders and addresses to the keystore
+func (c *common) saveKeystore() {
+	if c.store == nil || c.store.isLocked() {
+		return
+	}
+
+	var data keystoreData
+	c.dataM.RLock()
+	for _, display := range c.Data.Fwd.Display {
+		data.Fwds = append(data.Fwds, keystoreFwd{Name: display.Name, JSON: display.JSON})
+	}
+	for addr, display := range c.Data.Addr.Display {
+		if _, ok := c.hdGroupOf(addr); ok {
+			continue // saved with the group
+		}
+		if ad, ok := c.addrsDataMap[addr]; ok {
+			data.Addrs = append(data.Addrs, keystoreAddr{WIF: ad.wif.wif, FwdTo: display.FwdTo})
+		}
+	}
+
+	c.hd.m.Lock()
+	for _, g := range c.hd.groups {
+		data.Groups = append(data.Groups, keystoreGroup{XPrv: g.xprv, Path: g.path, Gap: int(g.gap), Next: g.next, FwdTo: g.fwdTo})
+	}
+	c.hd.m.Unlock()
+
+	if len(c.labels) > 0 {
+		data.Labels = make(map[string]string, len(c.labels))
+		for addr, label := range c.labels {
+			data.Labels[addr] = label
+		}
+	}
+	c.dataM.RUnlock()
+
+	err := c.store.save(data)
+	log.OnErr(err).Warnf("keystore save: %v", err)
+}
+
+// updateViewTop replaces any pending top view data with the latest,
+// so callers never block when the terminal is not running
+func (c *common) updateViewTop(data viewTopData) {
+	for {
+		select {
+		case c.term.update.viewTop <- data:
+			return
+		default:
+			select {
+			case <-c.term.update.viewTop:
+			default:
+			}
+		}
+	}
+}
+
+// updateViewBottom replaces any pending bottom view text with the
+// latest, so callers never block before the terminal is running
+func (c *common) updateViewBottom(text string) {
+	for {
+		select {
+		case c.term.update.viewBottom <- text:
+			return
+		default:
+			select {
+			case <-c.term.update.viewBottom:
+			default:
+			}
+		}
+	}
+}
+
+// shutdown stops reading the feed and waits for the address checkers to
+// finish the links that are queued and for the web server to stop. Anything
+// still running after the shutdown wait is aborted.
+func (c *common) shutdown() {
+	c.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownWait*time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		c.term.checkers.Wait()
+		close(done)
+	}()
+
+	err := c.web.server.Shutdown(ctx)
+	log.OnErr(err).Warnf("web server shutdown: %v", err)
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warnf("shutdown: aborting the emails that are still being checked")
+		c.abort()
+		<-done
+	}
+	c.abort()
+}
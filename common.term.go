@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/hmac"
@@ -9,12 +11,17 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/mail"
 	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jroimartin/gocui"
@@ -24,6 +31,7 @@ import (
 	"golang.org/x/crypto/openpgp/algorithm"
 	"golang.org/x/crypto/openpgp/ecdh"
 	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 // viewWidth the width of the terminal view
@@ -34,8 +42,29 @@ const viewTopHeight = 9
 
 // message holds the email headers and raw body string
 type Message struct {
-	Header mail.Header
-	Body   string
+	Header   mail.Header
+	Body     string
+	Verified string // pass, unsigned or fail, see verify
+}
+
+// Bytes returns the message as RFC 5322 text, the headers are sorted
+// because the order is lost when the message is parsed
+func (msg *Message) Bytes() []byte {
+	keys := make([]string, 0, len(msg.Header))
+	for k := range msg.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := new(bytes.Buffer)
+	for _, k := range keys {
+		for _, v := range msg.Header[k] {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.WriteString(msg.Body)
+	return buf.Bytes()
 }
 
 // commonTerm holds all of the terminal realted common stuff
@@ -45,6 +74,7 @@ type commonTerm struct {
 		startTime string
 		lastTime  string
 
+		m                     sync.Mutex // guards the durations, a config reload changes them
 		intervalWaitDuration  time.Duration
 		intervalNextDuration  time.Duration
 		intervalResetDuration time.Duration
@@ -60,7 +90,15 @@ type commonTerm struct {
 		readInterval time.Duration
 	}
 
-	done chan struct{}
+	// checkers is done when every address checker has returned
+	checkers sync.WaitGroup
+}
+
+// pollDuration returns one of the poll durations of the address checkers
+func (c *common) pollDuration(d *time.Duration) time.Duration {
+	c.term.check.m.Lock()
+	defer c.term.check.m.Unlock()
+	return *d
 }
 
 // viewTopData is the struct that is returned through
@@ -97,8 +135,59 @@ func (vw *viewWriter) WriteStringAt(text string, ln int) error {
 	return nil
 }
 
+// termUnlockKeystore prompts for the keystore passphrase on the terminal
+// and loads the saved forwarders and addresses. A new keystore asks for
+// the passphrase twice.
+func (c *common) termUnlockKeystore() error {
+	fd := int(os.Stdin.Fd())
+
+	if c.store.isNew() {
+		fmt.Printf("creating a new keystore at %s\n", c.store.path)
+		for {
+			fmt.Print("new keystore passphrase: ")
+			pass, err := terminal.ReadPassword(fd)
+			fmt.Println()
+			if err != nil {
+				return fmt.Errorf("read passphrase: %v", err)
+			}
+
+			fmt.Print("confirm passphrase: ")
+			confirm, err := terminal.ReadPassword(fd)
+			fmt.Println()
+			if err != nil {
+				return fmt.Errorf("read passphrase: %v", err)
+			}
+
+			if string(pass) == string(confirm) {
+				return c.loadKeystore(pass)
+			}
+			fmt.Println("the passphrases do not match")
+		}
+	}
+
+	var err error
+	for i := 0; i < 3; i++ {
+		fmt.Print("keystore passphrase: ")
+		var pass []byte
+		if pass, err = terminal.ReadPassword(fd); err != nil {
+			return fmt.Errorf("read passphrase: %v", err)
+		}
+		fmt.Println()
+
+		if err = c.loadKeystore(pass); err == nil {
+			return nil
+		}
+		fmt.Println(err)
+	}
+	return err
+}
+
 // terminal starts a terminal view
 func (c *common) terminal() {
+	if err := c.termUnlockKeystore(); err != nil {
+		log.Panicln(err)
+	}
+
 	g, err := gocui.NewGui(gocui.OutputNormal)
 	if err != nil {
 		log.Panicln(err)
@@ -111,7 +200,23 @@ func (c *common) terminal() {
 		log.Panicln(err)
 	}
 
-	go c.termReadFeed()
+	go c.termReadFeed(c.ctx)
+
+	c.term.checkers.Add(2)
+	go func() {
+		defer c.term.checkers.Done()
+		c.importPending()
+	}()
+	go func() {
+		defer c.term.checkers.Done()
+		c.runOutbox()
+	}()
+
+	// quit the terminal when a signal stops everything
+	go func() {
+		<-c.ctx.Done()
+		g.Update(func(g *gocui.Gui) error { return gocui.ErrQuit })
+	}()
 
 	if err := g.MainLoop(); err != nil && err != gocui.ErrQuit {
 		log.Panicln(err)
@@ -204,27 +309,77 @@ func (c *common) layout(g *gocui.Gui) (err error) {
 
 // quit is called when quiting the terminal application
 func (c *common) quit(g *gocui.Gui, v *gocui.View) error {
+	c.cancel()
 	return gocui.ErrQuit
 }
 
 // checkMetaLink takes the data from a meta link and checks to see if it is hashed
-// by the shared key of any private keys that were submitted.
+// by the shared key of any private keys that were submitted. Both the compressed
+// and uncompressed addresses are checked since either could have been used.
 func checkMetaLink(wif WIF, id, conHash, timestamp string) (hash string, ok bool) {
-	mmac := hmac.New(sha256.New, wif.sharedKey)
-	fmt.Fprintf(mmac, "com.pubkemail.meta.v1:%s/%s:%s", wif.addr, conHash, timestamp)
-	if ok = id == hex.EncodeToString(mmac.Sum(nil)); ok {
-		macc := hmac.New(sha256.New, wif.sharedKey)
-		fmt.Fprintf(macc, "com.pubkemail.content.v1:%s/%s:%s", wif.addr, conHash, timestamp)
-		hash = hex.EncodeToString(macc.Sum(nil))
-	}
-	return hash, ok
+	if wif.agent != nil {
+		hash, ok, err := wif.agent.check(wif.addr, id, conHash, timestamp)
+		log.OnErr(err).Warnf("agent check link: %v", err)
+		return hash, ok
+	}
+
+	for _, addr := range wif.addrs() {
+		mmac := hmac.New(sha256.New, wif.sharedKey)
+		fmt.Fprintf(mmac, "com.pubkemail.meta.v1:%s/%s:%s", addr, conHash, timestamp)
+		if id == hex.EncodeToString(mmac.Sum(nil)) {
+			macc := hmac.New(sha256.New, wif.sharedKey)
+			fmt.Fprintf(macc, "com.pubkemail.content.v1:%s/%s:%s", addr, conHash, timestamp)
+			return hex.EncodeToString(macc.Sum(nil)), true
+		}
+	}
+	return hash, false
 }
 
 // getContentMsg grabs the content from the web and decodes the message using the
-// shared key based on a supplied private key
-func getContentMsg(wif WIF, contentHash string, ts time.Time) (*Message, error) {
-	contentHashURL := fmt.Sprintf("https://content.pubkemail.com/v1/%s", contentHash)
-	resp, err := http.Get(contentHashURL)
+// shared key based on a supplied private key. The content is checked against the
+// SHA-256 from the feed link and the result is recorded on the message.
+func getContentMsg(ctx context.Context, wif WIF, contentHash, sum string, ts time.Time) (*Message, error) {
+	content, err := fetchContent(ctx, contentHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// with an agent the private key is never in this process,
+	// the agent decrypts the content and sends back the message
+	var body []byte
+	var sig contentSignature
+	if wif.agent != nil {
+		if body, sig, err = wif.agent.decrypt(wif.addr, content, ts); err != nil {
+			return nil, fmt.Errorf("agent decrypt message err: %v", err)
+		}
+	} else if body, sig, err = decryptContent(wif.priKey, wif.serverKey, bytes.NewReader(content), ts); err != nil {
+		return nil, err
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("parsing message: %v", err)
+	}
+
+	b, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		err = fmt.Errorf("reading message: %v", err)
+	}
+
+	message := &Message{Header: msg.Header, Body: string(b)}
+	message.verify(verifyContent(content, sum), sig)
+	return message, err
+}
+
+// fetchContent downloads the encrypted content for the content hash
+func fetchContent(ctx context.Context, contentHash string) ([]byte, error) {
+	contentHashURL := fmt.Sprintf("%s/v1/%s", apiURL.content, contentHash)
+	req, err := http.NewRequest(http.MethodGet, contentHashURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request for %s err: %v", contentHashURL, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("HTTP from %s err: %v", contentHashURL, err)
 	}
@@ -234,144 +389,320 @@ func getContentMsg(wif WIF, contentHash string, ts time.Time) (*Message, error)
 		return nil, fmt.Errorf("HTTP from %s status: %v", contentHashURL, http.StatusText(resp.StatusCode))
 	}
 
-	encPriKey := &ecdh.PrivateKey{
-		D: wif.priKey,
-		PublicKey: ecdh.PublicKey{
-			Curve: bitelliptic.S256(),
-			KDF: ecdh.KDF{
-				Hash:   algorithm.SHA512,
-				Cipher: algorithm.AES256,
-			},
-		},
-	}
-	encPriKey.PublicKey.X, encPriKey.PublicKey.Y = bitelliptic.S256().ScalarBaseMult(wif.priKey)
-	sigPriKey, err := ecdsa.GenerateKey(bitelliptic.S256(), rand.Reader)
+	content, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("generate signing private key err: %v", err)
+		return nil, fmt.Errorf("HTTP from %s read: %v", contentHashURL, err)
 	}
+	return content, nil
+}
 
-	data := encData{timestamp: ts, name: "Undisclosed", comment: "", email: "Undisclosed"}
-
-	decEntity, err := newEntity(data, sigPriKey, encPriKey)
+// decryptContent decrypts the base64 encoded PGP content with the private key
+// of a WIF. When the message is signed the signature is checked against the
+// pubkemail server key, the body is read to the end so that it can be.
+func decryptContent(priKey, serverKey []byte, content io.Reader, ts time.Time) ([]byte, contentSignature, error) {
+	var sig contentSignature
+	decEntity, err := newWIFEntity(priKey, ts)
 	if err != nil {
-		return nil, fmt.Errorf("creating a new entity err: %v", err)
+		return nil, sig, err
 	}
 
-	decBody := base64.NewDecoder(base64.StdEncoding, resp.Body)
-	pgpMsg, err := openpgp.ReadMessage(decBody, openpgp.EntityList{decEntity}, nil, &packet.Config{
+	keyring := openpgp.EntityList{decEntity}
+	if len(serverKey) > 0 {
+		signer, err := newServerEntity(serverKey, ts)
+		if err != nil {
+			return nil, sig, err
+		}
+		keyring = append(keyring, signer)
+	}
+
+	decBody := base64.NewDecoder(base64.StdEncoding, content)
+	pgpMsg, err := openpgp.ReadMessage(decBody, keyring, nil, &packet.Config{
 		DefaultHash: crypto.RIPEMD160,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("decrypt message err: %v", err)
+		return nil, sig, fmt.Errorf("decrypt message err: %v", err)
 	}
 
-	msg, err := mail.ReadMessage(pgpMsg.UnverifiedBody)
+	body, err := ioutil.ReadAll(pgpMsg.UnverifiedBody)
 	if err != nil {
-		err = fmt.Errorf("parsing message: %v", err)
+		return nil, sig, fmt.Errorf("decrypt message read: %v", err)
 	}
+	return body, messageSignature(pgpMsg), nil
+}
 
-	b, err := ioutil.ReadAll(msg.Body)
+// newWIFEntity returns the PGP entity for the private key of a WIF, the
+// encryption subkey only depends on the key and the timestamp so it
+// matches the key pubkemail used to encrypt the message
+func newWIFEntity(priKey []byte, ts time.Time) (*openpgp.Entity, error) {
+	encPriKey := newECDHKey(priKey)
+	sigPriKey, err := ecdsa.GenerateKey(bitelliptic.S256(), rand.Reader)
 	if err != nil {
-		err = fmt.Errorf("reading message: %v", err)
+		return nil, fmt.Errorf("generate signing private key err: %v", err)
+	}
+
+	data := encData{timestamp: ts, name: "Undisclosed", comment: "", email: "Undisclosed"}
+
+	entity, err := newEntity(data, sigPriKey, encPriKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating a new entity err: %v", err)
 	}
+	return entity, nil
+}
 
-	return &Message{Header: msg.Header, Body: string(b)}, err
+// newECDHKey returns the PGP ECDH encryption key for a private key
+func newECDHKey(priKey []byte) *ecdh.PrivateKey {
+	encPriKey := &ecdh.PrivateKey{
+		D: priKey,
+		PublicKey: ecdh.PublicKey{
+			Curve: bitelliptic.S256(),
+			KDF: ecdh.KDF{
+				Hash:   algorithm.SHA512,
+				Cipher: algorithm.AES256,
+			},
+		},
+	}
+	encPriKey.PublicKey.X, encPriKey.PublicKey.Y = bitelliptic.S256().ScalarBaseMult(priKey)
+	return encPriKey
 }
 
 // termAddrChecker is a function that holds the channel that links
 // are sent back on to be checked against. If it's valid it will
 // initiate a download and forward the message using the supplied
-// forwarding data
-func (c *common) termAddrChecker(addr string) { // checks if link is \
+// forwarding data. Once the context is done any links that are
+// still queued are checked before returning.
+func (c *common) termAddrChecker(ctx context.Context, addr string) { // checks if link is \
+	defer c.term.checkers.Done()
+
+	// the feed is read newest first, so the watermark is taken once. If it
+	// was read for each link, forwarding the newest message would move it
+	// past the older messages that haven't been checked yet.
+	after := c.afterDate(addr)
+
+	data, _ := c.addrDataOf(addr)
+	feedLinks := data.feedLinks
 	for {
 		select {
-		case link := <-c.addrsDataMap[addr].feedLinks:
-
-			u, err := url.Parse(link)
-			if err != nil {
-				log.Warnf("address check parse link url (%q): %v", link, err)
-				continue
+		case link := <-feedLinks:
+			c.termCheckLink(addr, link, after)
+			c.updateViewTop(viewTopData{lastCheckTime: time.Now().Format(time.RFC3339)})
+		case <-ctx.Done():
+			for {
+				select {
+				case link := <-feedLinks:
+					c.termCheckLink(addr, link, after)
+				default:
+					return
+				}
 			}
+		}
+		sleepCtx(ctx, c.pollDuration(&c.term.check.intervalWaitDuration))
+	}
+}
 
-			wif := c.addrsDataMap[addr].wif
-			if contentEmailHash, ok := checkMetaLink(wif, u.Query().Get("check"), u.Query().Get("hash"), u.Query().Get("ts")); ok {
-				c.Data.Addr.incrNewMailCnt(addr)
+// termCheckLink checks a single feed link against an address, if the link
+// is for the address and after the time given the message is downloaded,
+// archived and forwarded
+func (c *common) termCheckLink(addr, link string, after time.Time) {
+	u, err := url.Parse(link)
+	if err != nil {
+		log.Warnf("address check parse link url (%q): %v", link, err)
+		return
+	}
 
-				ts, err := strconv.ParseInt(u.Query().Get("ts"), 10, 64)
-				if err != nil {
-					log.Warnf("parsing timestamp err: %v", err)
-					continue
-				}
+	data, _ := c.addrDataOf(addr)
+	wif := data.wif
+	contentEmailHash, ok := checkMetaLink(wif, u.Query().Get("check"), u.Query().Get("hash"), u.Query().Get("ts"))
+	if !ok {
+		return
+	}
+	c.markHDUsed(addr)
 
-				tsThen := time.Unix(0, ts)
-				if !tsThen.After(c.term.check.afterDate) {
-					continue
-				}
+	ts, err := strconv.ParseInt(u.Query().Get("ts"), 10, 64)
+	if err != nil {
+		log.Warnf("parsing timestamp err: %v", err)
+		return
+	}
 
-				message, err := getContentMsg(wif, contentEmailHash, tsThen)
-				if err != nil {
-					log.Warnf("retriving email message: %v", err)
-					continue
-				}
+	tsThen, sum := time.Unix(0, ts), u.Query().Get("hash")
+	if wif.isWatchOnly() {
+		c.termRecordPending(addr, contentEmailHash, sum, tsThen, after)
+		return
+	}
+	c.termDeliver(addr, contentEmailHash, sum, tsThen, after)
+}
 
-				addrDisplay, ok := c.Data.Addr.Display[addr]
-				if !ok {
-					log.Warnf("display for address not found")
-					continue
-				}
+// termRecordPending records that mail is waiting for a watch-only address,
+// the message is decrypted later on a machine that holds the WIF
+func (c *common) termRecordPending(addr, contentEmailHash, sum string, tsThen, after time.Time) {
+	if _, seen := c.ledger.get(contentEmailHash); seen {
+		return
+	}
+	if !tsThen.After(after) {
+		return
+	}
 
-				fwdTo := addrDisplay.FwdTo
-				if fn, ok := c.fwdDataMap[fwdTo]; ok {
-					from := message.Header.Get("From")
-					subj := message.Header.Get("Subject")
-					fn.fwdEmail(from, subj, message.Body, message.Header, false)
-				}
-			}
+	c.Data.Addr.incrNewMailCnt(addr)
+	err := c.ledger.pending(ledgerEntry{Addr: addr, Hash: contentEmailHash, Sum: sum, TS: tsThen})
+	log.OnErr(err).Warnf("ledger record: %v", err)
+	log.Warnf("mail waiting for watch-only address %s: %s", addr, contentEmailHash)
+
+	c.updateAddrDisplay(addr, func(display *AddrDisplay) { display.Waiting++ })
+}
+
+// termDeliver downloads, archives and queues a message for the address in
+// the outbox, which forwards it. A message that fails verification is
+// archived in the quarantine folder of the maildir and never forwarded.
+// Anything new must be after the time given, see termAddrChecker.
+func (c *common) termDeliver(addr, contentEmailHash, sum string, tsThen, after time.Time) {
+	var err error
+	data, _ := c.addrDataOf(addr)
+	wif := data.wif
+
+	// anything in the ledger that failed is retried with a backoff until
+	// it's dead (anything pending or queued is always retried), anything
+	// new must be after the watermark the checker took (or the --after
+	// flag) and anything in the outbox is left for it to retry
+	entry, seen := c.ledger.get(contentEmailHash)
+	switch {
+	case !seen:
+	case entry.Status == ledgerForwarded, entry.Status == ledgerQuarantined,
+		entry.Status == ledgerDiscarded, entry.Status == ledgerDead:
+		return
+	case entry.Status == ledgerFailed && time.Now().Before(entry.retryAt()):
+		return
+	}
+	if c.outbox.has(contentEmailHash) {
+		return
+	}
+	if !seen && !tsThen.After(after) {
+		return
+	}
+
+	c.Data.Addr.incrNewMailCnt(addr)
 
-			c.term.update.viewTop <- viewTopData{lastCheckTime: time.Now().Format(time.RFC3339)}
-		case <-c.term.done:
-			c.addrsDataMap[addr].feedLinksDone.Done()
-			break
+	// the maildir archive is the source of truth, so a message that
+	// failed to forward before is read back instead of downloaded
+	var message *Message
+	if entry.File != "" {
+		message, err = maildirRead(c.maildirPath(addr), entry.File)
+	} else {
+		message, err = getContentMsg(c.work, wif, contentEmailHash, sum, tsThen)
+	}
+	if err != nil {
+		log.Warnf("retriving email message: %v", err)
+		lerr := c.ledger.record(ledgerEntry{Addr: addr, Hash: contentEmailHash, Sum: sum, TS: tsThen, File: entry.File}, err)
+		log.OnErr(lerr).Warnf("ledger record: %v", lerr)
+		if e, _ := c.ledger.get(contentEmailHash); e.Status == ledgerDead {
+			log.Warnf("email message %s failed for good after %d attempt(s)", contentEmailHash, e.Tries)
 		}
-		time.Sleep(c.term.check.intervalWaitDuration)
+		return
 	}
+
+	if message.Verified == verifyFail {
+		c.termQuarantine(addr, contentEmailHash, sum, tsThen, message)
+		return
+	}
+
+	file := entry.File
+	if file == "" {
+		file, err = maildirDeliver(c.maildirPath(addr), message)
+		log.OnErr(err).Warnf("archive email message: %v", err)
+	}
+
+	c.queueMessage(addr, contentEmailHash, sum, tsThen, file, message)
+}
+
+// termQuarantine archives a message that failed verification in the quarantine
+// folder of the maildir so that it can be looked at, it's never forwarded
+func (c *common) termQuarantine(addr, contentEmailHash, sum string, tsThen time.Time, message *Message) {
+	reason := message.Header.Get(verifiedHeader)
+	log.Warnf("quarantined email message for %s: %s", addr, reason)
+
+	file, err := maildirDeliver(filepath.Join(c.maildirPath(addr), maildirQuarantine), message)
+	log.OnErr(err).Warnf("archive email message: %v", err)
+
+	err = c.ledger.quarantine(ledgerEntry{Addr: addr, Hash: contentEmailHash, Sum: sum, TS: tsThen, File: file}, fmt.Errorf("verify: %s", reason))
+	log.OnErr(err).Warnf("ledger record: %v", err)
+
+	c.updateAddrDisplay(addr, func(display *AddrDisplay) { display.Quarantined++ })
 }
 
 // termReadFeed checks the RSS feed on an interval and sends back the meta links
-// it finds to be checked against the shared keys of the supplied addresses
-func (c *common) termReadFeed() {
+// it finds to be checked against the shared keys of the supplied addresses. It
+// returns when the context is done.
+func (c *common) termReadFeed(ctx context.Context) {
 	page, limit := 1, 250
-	for {
+	for ctx.Err() == nil {
 		log.Println("checking...")
-		if len(c.addrsDataMap) == 0 {
-			time.Sleep(c.term.check.intervalNextDuration)
+		c.growHDGroups()
+		c.loadAgentKeys()
+		feedLinks := c.feedLinks()
+		if len(feedLinks) == 0 {
+			sleepCtx(ctx, c.pollDuration(&c.term.check.intervalNextDuration))
 			continue
 		}
-		fp := gofeed.NewParser()
-		feedURL := fmt.Sprintf("https://rss.pubkemail.com/feed?page=%d&limit=%d", page, limit)
-		feed, err := fp.ParseURL(feedURL)
+		feedURL := fmt.Sprintf("%s/feed?page=%d&limit=%d", apiURL.rss, page, limit)
+		feed, err := readFeed(ctx, feedURL)
 		if err != nil {
-			log.Warnf("gathering the feed URL", err)
+			log.Warnf("gathering the feed URL: %v", err)
 			page = 1
-			time.Sleep(c.term.check.intervalResetDuration)
+			sleepCtx(ctx, c.pollDuration(&c.term.check.intervalResetDuration))
 			continue
 		}
 		if len(feed.Items) == 0 {
 			page = 1
-			time.Sleep(c.term.check.intervalNextDuration)
-			break
+			sleepCtx(ctx, c.pollDuration(&c.term.check.intervalNextDuration))
+			continue
 		}
 		for _, item := range feed.Items {
 			if item.GUID == "GENESIS-ITEM" {
 				page = 1
-				time.Sleep(c.term.check.intervalResetDuration)
+				sleepCtx(ctx, c.pollDuration(&c.term.check.intervalResetDuration))
 				break
 			}
-			for _, f := range c.addrsDataMap {
-				f.feedLinks <- item.Link
+			for _, links := range feedLinks {
+				select {
+				case links <- item.Link:
+				case <-ctx.Done():
+					return
+				}
 			}
 			page++
 		}
-		time.Sleep(c.term.check.intervalWaitDuration)
+		sleepCtx(ctx, c.pollDuration(&c.term.check.intervalWaitDuration))
+	}
+}
+
+// feedLinks returns the link channels of the addresses that are
+// being checked, they're sent to without holding the lock
+func (c *common) feedLinks() (links []chan string) {
+	c.dataM.RLock()
+	defer c.dataM.RUnlock()
+	for _, f := range c.addrsDataMap {
+		if f.isFwd { // otherwise nothing is reading the links
+			links = append(links, f.feedLinks)
+		}
 	}
+	return links
+}
+
+// readFeed downloads and parses the RSS feed, the download
+// is stopped if the context is done
+func readFeed(ctx context.Context, feedURL string) (*gofeed.Feed, error) {
+	req, err := http.NewRequest(http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP from %s status: %v", feedURL, http.StatusText(resp.StatusCode))
+	}
+
+	return gofeed.NewParser().Parse(resp.Body)
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// newTestCommon returns a client with its data directory in a temp dir that
+// uses a simulator for the pubkemail services, the simulator sends to wif
+func newTestCommon(t *testing.T) (c *common, sim *simulator, wif WIF, cleanup func()) {
+	dir, err := ioutil.TempDir("", "pubkemail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/feed", func(w http.ResponseWriter, r *http.Request) { sim.feedHandler(w, r) })
+	r.Get("/v1/{hash}", func(w http.ResponseWriter, r *http.Request) { sim.contentHandler(w, r) })
+	r.Get("/public/key/{x}", func(w http.ResponseWriter, r *http.Request) { sim.publicKeyHandler(w, r) })
+	srv := httptest.NewServer(r)
+
+	prevURL := apiURL
+	apiURL.rss, apiURL.content, apiURL.shared = srv.URL, srv.URL, srv.URL
+	cleanup = func() {
+		c.cancel()
+		c.term.checkers.Wait()
+		srv.Close()
+		apiURL = prevURL
+		os.RemoveAll(dir)
+	}
+
+	// the simulator's key is needed to decode the WIF, so it's made first
+	if sim, err = newSimulator(srv.URL, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	c = newCommon(func(c *common) { c.dataDir = dir })
+	if wif, err = generateWIF("btc"); err == nil {
+		wif, err = unmarshalWIF(wif.wif) // the shared key is from the simulator
+	}
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	sim.wifs = []WIF{wif}
+	return c, sim, wif, cleanup
+}
+
+// TestTermCheckLinkOlderUnseen forwards two unseen messages that are in the
+// feed newest first, forwarding the newest must not skip the older one
+func TestTermCheckLinkOlderUnseen(t *testing.T) {
+	c, sim, wif, cleanup := newTestCommon(t)
+	defer cleanup()
+
+	var m sync.Mutex
+	var subjects []string
+	fwdSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		subjects = append(subjects, r.PostFormValue("subject"))
+		m.Unlock()
+	}))
+	defer fwdSrv.Close()
+
+	_, err := c.addFwd("test", fmt.Sprintf(`{"http-api": {"v2": {"url": %q, "method": "POST", "body": "form",
+		"parameters": {"subject": ["{{ .Subject }}"]}}}}`, fwdSrv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.addAddr(wif, "test")
+
+	now := time.Now()
+	for _, ts := range []time.Time{now.Add(-2 * time.Minute), now.Add(-time.Minute)} {
+		if err := sim.send(wif, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	after := c.afterDate(wif.addr)
+	for _, item := range sim.items { // newest first, like the feed
+		c.termCheckLink(wif.addr, item.Link, after)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	want := []string{"Simulated email #2", "Simulated email #1"}
+	if fmt.Sprint(subjects) != fmt.Sprint(want) {
+		t.Errorf("forwarded: got %q want %q", subjects, want)
+	}
+	if got, want := c.ledger.watermark(wif.addr), now.Add(-time.Minute); !got.Equal(want) {
+		t.Errorf("watermark: got %s want %s", got, want)
+	}
+}
@@ -2,16 +2,15 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
-	"net/mail"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -59,6 +58,7 @@ type commonWeb struct {
 	port       string
 	portUpdate chan string
 	templates  map[string]*template.Template
+	server     *http.Server
 
 	randPrefix string
 	useLocalFS bool
@@ -96,7 +96,7 @@ func (c *common) loadTemplates() error {
 		rdrs = append(rdrs, ft)
 	}
 
-	for _, name := range []string{"/index.html", "/compose.html", "/email.html", "/pricing.html"} {
+	for _, name := range []string{"/index.html", "/compose.html", "/email.html", "/pricing.html", "/keygen.html", "/outbox.html"} {
 		f, err := fs.Open(name)
 		if err != nil {
 			panic(err)
@@ -123,12 +123,18 @@ func (c *common) loadTemplates() error {
 	return nil
 }
 
-// webServer runs the webserver for the web page interface
-func (c *common) webServer() {
+// newWebServer builds the webserver for the web page interface, it's
+// built up front so that shutdown can stop it before it's serving
+func (c *common) newWebServer() *http.Server {
 	r := chi.NewRouter()
 	r.Get(fmt.Sprintf("/%s/*", c.web.randPrefix), c.webGetHandler)
+	r.Post(fmt.Sprintf("/%s/%s", c.web.randPrefix, webRegisterPath), c.webRegisterHandler)
 	r.Post(fmt.Sprintf("/%s*", c.web.randPrefix), c.webIndexHandler)
+	return &http.Server{Handler: r}
+}
 
+// webServer runs the webserver for the web page interface
+func (c *common) webServer() {
 	listener, err := net.Listen("tcp", c.web.port)
 	if err != nil {
 		panic(err)
@@ -138,7 +144,11 @@ func (c *common) webServer() {
 		c.web.portUpdate <- fmt.Sprintf(":%d", listener.Addr().(*net.TCPAddr).Port)
 	}
 	close(c.web.portUpdate)
-	http.Serve(listener, r) // no need for gracefull shutdown
+
+	// a shutdown before this point makes Serve return right away
+	if err = c.web.server.Serve(listener); err != http.ErrServerClosed {
+		log.Warnf("web server: %v", err)
+	}
 }
 
 // webGetHandler is the standard handler that displays each page
@@ -165,6 +175,15 @@ func (c *common) webGetHandler(w http.ResponseWriter, r *http.Request) {
 
 	var f io.ReadSeeker
 	if page, ok := c.web.templates[name]; ok {
+		var outboxDisplay []OutboxDisplay
+		if name == "/outbox.html" {
+			outboxDisplay = c.outboxDisplay()
+		}
+
+		// the page is shown while holding the locks of the maps it ranges
+		// over, the feed reader can add to them at any time
+		c.dataM.Lock()
+		c.Data.Addr.m.Lock()
 		c.Data.RequestURI, c.Data.RequestURIPath = r.RequestURI, path.Dir(r.RequestURI)
 
 		switch name {
@@ -172,6 +191,8 @@ func (c *common) webGetHandler(w http.ResponseWriter, r *http.Request) {
 			c.Data.TopFlags["pricing"] = "pricing"
 		case "/compose.html", "/email.html":
 			c.Data.BottomFlags["overlay"] = "overlay"
+		case "/outbox.html":
+			c.Data.Outbox.Display = outboxDisplay
 		}
 
 		c.Data.TopFlags["page"] = name
@@ -180,6 +201,12 @@ func (c *common) webGetHandler(w http.ResponseWriter, r *http.Request) {
 		buf := new(bytes.Buffer)
 		page.Execute(buf, c.Data)
 		f = strings.NewReader(buf.String())
+
+		if name == "/keygen.html" {
+			c.Data.Keygen.Display = nil // generated WIFs are only shown once
+		}
+		c.Data.Addr.m.Unlock()
+		c.dataM.Unlock()
 	} else {
 		var err error
 		fs := FS(c.web.useLocalFS)
@@ -193,6 +220,26 @@ func (c *common) webGetHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, name, time.Time{}, f)
 }
 
+// webRegisterHandler adds the posted WIF like the Add WIF form, but it
+// answers with a status instead of redirecting back to a page so that
+// keygen --register can tell when the WIF wasn't added
+func (c *common) webRegisterHandler(w http.ResponseWriter, r *http.Request) {
+	wifStr := r.PostFormValue(formWIFStr)
+	if wifStr == "" {
+		http.Error(w, "no WIF was posted", http.StatusBadRequest)
+		return
+	}
+
+	if err := c.addSecret(wifStr, "", "", 0, c.anyFwdName()); err != nil {
+		log.Warnf("webRegisterHandler:: add wif: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	c.saveKeystore()
+
+	w.WriteHeader(http.StatusCreated)
+}
+
 // webIndexHandler handes the post back interaction from the index page
 func (c *common) webIndexHandler(w http.ResponseWriter, r *http.Request) {
 	fn := "webIndexHandler::"
@@ -234,47 +281,62 @@ func (c *common) webIndexHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch subVal := values.Get(c.Data.Const.Submit); subVal {
 	case c.Data.Const.SubmitAddWIF:
-		var wif WIF
-		var fwdTo string
+		var gap int
+		fwdTo := c.anyFwdName() // just grab a random one
+
+		if gapStr := values.Get(c.Data.Const.HDGap); gapStr != "" {
+			if gap, err = strconv.Atoi(gapStr); err != nil || gap <= 0 {
+				err = webFriendlyErr{
+					fmt.Errorf("%s hd gap %q: %v", fn, gapStr, err),
+					"The gap limit must be a number greater than zero.",
+				}
+				return
+			}
+		}
 
-		wif, err = unmarshalWIF(values.Get(c.Data.Const.WIFStr))
+		err = c.addSecret(values.Get(c.Data.Const.WIFStr), values.Get(c.Data.Const.WIFPass), values.Get(c.Data.Const.HDPath), gap, fwdTo)
 		if err != nil {
 			err = webFriendlyErr{
-				fmt.Errorf("%s unmarshal wif: %v", fn, err),
-				"Something went wrong. Please Retry.",
+				fmt.Errorf("%s add key: %v", fn, err),
+				"The key could not be added. Please check the key, passphrase and derivation path and retry.",
 			}
 			return
 		}
 
-		for fwdTo = range c.fwdDataMap {
-			break // just grab a random one
-		}
-
-		c.Data.Addr.NewMail[wif.addr] = 0
-		c.Data.Addr.Display[wif.addr] = AddrDisplay{
-			Addr:  wif.addr,
-			WIF:   wif.wif,
-			FwdTo: fwdTo,
-		}
-
-		isFwd := len(c.Data.Addr.Display[wif.addr].FwdTo) > 0
-		c.addrsDataMap[wif.addr] = addrData{
-			isFwd:     isFwd,
-			feedLinks: make(chan string, defaultFeedLinksChanLen),
-			wif:       wif,
-		}
-
-		if isFwd {
-			go c.termAddrChecker(wif.addr)
-		}
-		c.term.update.viewBottom <- addrDataMapToString(c.addrsDataMap)
-
+		c.saveKeystore()
 		return
 	case c.Data.Const.SubmitFwd, c.Data.Const.SubmitFwdTest:
 		var fwdNameText = values.Get(c.Data.Const.FwdName)
 		var fwdJSONText = values.Get(c.Data.Const.FwdJSON)
 		var isTest = (subVal == c.Data.Const.SubmitFwdTest)
 
+		// a preset fills in the JSON, it's only used when there's no JSON
+		if preset := values.Get(c.Data.Const.FwdPreset); preset != "" && len(strings.TrimSpace(fwdJSONText)) == 0 {
+			var to []string
+			for _, addr := range strings.Split(values.Get(c.Data.Const.FwdPresetTo), ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					to = append(to, addr)
+				}
+			}
+
+			fwdJSONText, err = fwdPresetJSON(fwdPreset{
+				Name:   preset,
+				Key:    strings.TrimSpace(values.Get(c.Data.Const.FwdPresetKey)),
+				Secret: strings.TrimSpace(values.Get(c.Data.Const.FwdPresetSecret)),
+				Domain: strings.TrimSpace(values.Get(c.Data.Const.FwdPresetDomain)),
+				Region: strings.TrimSpace(values.Get(c.Data.Const.FwdPresetRegion)),
+				To:     to,
+			})
+			if err != nil {
+				c.Data.FwdNameText = fwdNameText
+				err = webFriendlyErr{
+					fmt.Errorf("%s %v", fn, err),
+					"The preset is missing something, it needs an API key, a domain and a recipient (and a secret for Mailjet and Amazon SES).",
+				}
+				return
+			}
+		}
+
 		if !isTest && len(strings.TrimSpace(fwdNameText)) == 0 {
 			c.Data.FwdJSONText = fwdJSONText
 			err = webFriendlyErr{
@@ -289,89 +351,166 @@ func (c *common) webIndexHandler(w http.ResponseWriter, r *http.Request) {
 				fmt.Sprintf("You have deleted the Forwarding: %s.", fwdNameText),
 			}
 
-			for addr, display := range c.Data.Addr.Display {
-				name := strings.Replace(fwdNameText, " ", "-", -1)
-				if display.FwdTo == name {
-					display.FwdTo = ""
-					if data, ok := c.addrsDataMap[addr]; ok {
-						data.isFwd = false
-						c.addrsDataMap[addr] = data
-					}
-					c.Data.Addr.Display[addr] = display
-					delete(c.fwdDataMap, name)
+			c.delFwd(fwdNameText)
+			c.saveKeystore()
+			return
+		}
+
+		if isTest {
+			c.Data.FwdNameText = fwdNameText
+			c.Data.FwdJSONText = fwdJSONText
+
+			var fwdEmail fwdEmailFunc
+			fwdEmail, _, err = fwdEmailFromJSON(fwdJSONText)
+			if err != nil {
+				err = webFriendlyErr{
+					fmt.Errorf("%s %v", fn, err),
+					"The JSON submitted is invalid. Please check and retry.",
 				}
+				return
 			}
+
+			testSubject := fmt.Sprintf("Testing 123 - %d", time.Now().Unix())
+			testBody := "This is a test email sent @: " + time.Now().Format(time.RFC822)
+			err := fwdEmail(r.Context(), fwdRcpt{}, "test@example.com", testSubject, testBody, nil, isTest)
+			log.OnErr(err).Printf("fwd email: %v", err)
 			return
 		}
 
-		var via fwdVia
-		err = json.Unmarshal([]byte(fwdJSONText), &via)
+		var kind string
+		kind, err = c.addFwd(fwdNameText, fwdJSONText)
 		if err != nil {
 			err = webFriendlyErr{
-				fmt.Errorf("%s json unmarshal: %v", fn, err),
+				fmt.Errorf("%s %v", fn, err),
 				"The JSON submitted is invalid. Please check and retry.",
 			}
 			return
 		}
 
-		var fwdEmail fwdEmailFunc
-		switch {
-		case via.fwdViaHTTPAPI != nil:
-			// the wrapper to forward mails via HTTP API calls
-			fwdEmail = func(from, subject, body string, headers mail.Header, isTest bool) error {
-				return fwdHTTPAPIEmail(via, from, subject, body, headers, isTest)
-			}
-			err = webFriendlyInfo{
-				fmt.Sprintf("You have added the HTTP API forwarding JSON: %s", fwdNameText),
-			}
-		case via.fwdViaSMTP != nil:
-			// the wrapper to forward mails via SMTP calls
-			fwdEmail = func(from, subject, body string, headers mail.Header, isTest bool) error {
-				return fwdSMTPEmail(via, from, subject, body, headers, isTest)
+		c.Data.FwdNameText = ""
+		c.Data.FwdJSONText = ""
+		c.saveKeystore()
+
+		err = webFriendlyInfo{
+			fmt.Sprintf("You have added the %s forwarding JSON: %s", kind, fwdNameText),
+		}
+		return
+	case c.Data.Const.SubmitKeygen:
+		fwdTo := c.anyFwdName() // just grab a random one
+
+		count, _ := strconv.Atoi(values.Get(c.Data.Const.KeygenCount))
+		if count < 1 || count > 20 {
+			count = 1
+		}
+
+		var display []KeygenDisplay
+		for i := 0; i < count; i++ {
+			var wif WIF
+			wif, err = generateWIF(values.Get(c.Data.Const.KeygenNet))
+			if err == nil {
+				wif, err = unmarshalWIF(wif.wif)
 			}
-			err = webFriendlyInfo{
-				fmt.Sprintf("You have added the SMTP forwarding JSON: %s", fwdNameText),
+			if err != nil {
+				err = webFriendlyErr{
+					fmt.Errorf("%s keygen: %v", fn, err),
+					"The key could not be generated. Please Retry.",
+				}
+				break
 			}
-		default:
+
+			c.addAddr(wif, fwdTo)
+			display = append(display, KeygenDisplay{
+				CurAbv: wif.currency,
+				WIF:    wif.wif,
+				Email:  wif.addr + "@pubkemail.com",
+			})
+		}
+
+		// the keys that were added are shown even when one failed, the page
+		// clears them once it has shown them
+		c.dataM.Lock()
+		c.Data.Keygen.Display = display
+		c.dataM.Unlock()
+		if len(display) > 0 {
+			c.saveKeystore()
+		}
+		if err != nil {
+			return
+		}
+
+		err = webFriendlyInfo{
+			fmt.Sprintf("You have generated %d new address(es), they are now watched.", count),
+		}
+		return
+	case c.Data.Const.SubmitOutboxRetry:
+		var item outboxItem
+		hash := values.Get(c.Data.Const.OutboxHash)
+		if item, err = c.outbox.retry(hash); err != nil {
 			err = webFriendlyErr{
-				fmt.Errorf("%s json unmarshal: %v", fn, err),
-				"The JSON submitted is invalid. Please check and retry.",
+				fmt.Errorf("%s outbox retry: %v", fn, err),
+				"The message is no longer in the outbox.",
 			}
+			return
 		}
 
-		if isTest {
-			c.Data.FwdNameText = fwdNameText
-			c.Data.FwdJSONText = fwdJSONText
+		// the ledger starts over too, the outbox records how it ends
+		lerr := c.ledger.queued(ledgerEntry{Addr: item.Addr, Hash: item.Hash, Sum: item.Sum, TS: item.TS, File: item.File})
+		log.OnErr(lerr).Warnf("ledger record: %v", lerr)
 
-			testSubject := fmt.Sprintf("Testing 123 - %d", time.Now().Unix())
-			testBody := "This is a test email sent @: " + time.Now().Format(time.RFC822)
-			err := fwdEmail("test@example.com", testSubject, testBody, nil, isTest)
-			log.OnErr(err).Printf("fwd email: %v", err)
+		err = webFriendlyInfo{
+			fmt.Sprintf("The message %s will be forwarded again shortly.", hash),
+		}
+		return
+	case c.Data.Const.SubmitOutboxDiscard:
+		var item outboxItem
+		hash := values.Get(c.Data.Const.OutboxHash)
+		if item, err = c.outbox.discard(hash); err != nil {
+			err = webFriendlyErr{
+				fmt.Errorf("%s outbox discard: %v", fn, err),
+				"The message could not be discarded, it may be sending. Please Retry.",
+			}
 			return
 		}
 
-		c.Data.FwdNameText = ""
-		c.Data.FwdJSONText = ""
+		// the ledger keeps it from being downloaded and queued again
+		lerr := c.ledger.discard(ledgerEntry{Addr: item.Addr, Hash: item.Hash, Sum: item.Sum, TS: item.TS, File: item.File})
+		log.OnErr(lerr).Warnf("ledger record: %v", lerr)
 
-		name := strings.Replace(fwdNameText, " ", "-", -1)
-		c.fwdDataMap[name] = fwdData{fwdEmail: fwdEmail}
-		c.Data.Fwd.Display[name] = FwdDisplay{
-			Name: fwdNameText,
-			JSON: fwdJSONText,
+		err = webFriendlyInfo{
+			fmt.Sprintf("The message %s has been discarded, it won't be forwarded.", hash),
 		}
-
 		return
 	case c.Data.Const.SubmitFwdTo:
+		groups := make(map[string]bool)
 		for k, v := range values {
 			if k == c.Data.Const.Submit {
 				continue
 			}
 
-			if val, ok := c.Data.Addr.Display[k]; ok && len(v) > 0 {
-				val.FwdTo = v[0]
-				c.Data.Addr.Display[k] = val
+			if addr := strings.TrimPrefix(k, c.Data.Const.AddrLabel); addr != k && len(v) > 0 {
+				if _, ok := c.addrDisplayOf(addr); ok {
+					c.setLabel(addr, v[0])
+				}
+				continue
+			}
+
+			if val, ok := c.addrDisplayOf(k); ok && len(v) > 0 && val.FwdTo != v[0] {
+				// every address in a group shares a forwarder, so
+				// changing one of them changes the whole group
+				if xprv, ok := c.hdGroupOf(k); ok {
+					if !groups[xprv] {
+						groups[xprv] = true
+						c.setHDGroupFwd(xprv, v[0])
+					}
+					continue
+				}
+				// added again so that the address is checked right away
+				if data, ok := c.addrDataOf(k); ok {
+					c.addAddr(data.wif, v[0])
+				}
 			}
 		}
+		c.saveKeystore()
 		return
 	}
 
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ghodss/yaml"
+	flag "github.com/spf13/pflag"
+)
+
+// envPrefix is the prefix for all environment variables that override
+// settings from the config file
+const envPrefix = "PUBKEMAIL_"
+
+// config holds the settings that can be declared in a YAML, JSON or TOML
+// file so that the client can be provisioned without the web interface
+type config struct {
+	WebPort *int   `json:"web-port,omitempty"`
+	After   string `json:"after,omitempty"`
+	DataDir string `json:"data-dir,omitempty"`
+
+	// KeyManifest is a file of pubkemail shared public keys signed with the release key
+	KeyManifest string `json:"key-manifest,omitempty"`
+	// ServerKeys is a PEM file or directory of the shared public keys, so they are never fetched
+	ServerKeys string `json:"server-keys,omitempty"`
+	// Agent is the Unix socket of a pubkemail agent that holds the WIFs
+	Agent string `json:"agent,omitempty"`
+
+	API struct {
+		RSS     string `json:"rss,omitempty"`
+		Content string `json:"content,omitempty"`
+		Shared  string `json:"shared,omitempty"`
+	} `json:"api,omitempty"`
+
+	Poll struct {
+		Next  string `json:"next,omitempty"`
+		Wait  string `json:"wait,omitempty"`
+		Reset string `json:"reset,omitempty"`
+	} `json:"poll,omitempty"`
+
+	// Forwarders are keyed by name and use the same JSON as the web interface
+	Forwarders map[string]json.RawMessage `json:"forwarders,omitempty"`
+	Addresses  []configAddr               `json:"addresses,omitempty"`
+	// Labels name the addresses for the forwarder templates, keyed by address
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// configAddr is a key and the name of the forwarder it's assigned to. The
+// key can be a WIF, BIP38 encrypted key, xprv or BIP39 seed phrase, the
+// passphrase, path and gap are only used by the formats that need them.
+type configAddr struct {
+	WIF        string `json:"wif"`
+	Passphrase string `json:"passphrase,omitempty"`
+	FwdTo      string `json:"forward,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Gap        int    `json:"gap,omitempty"`
+}
+
+// loadConfig reads the config file at path, the format is picked
+// by the file extension. An empty path returns an empty config.
+func loadConfig(path string) (cfg config, err error) {
+	if path == "" {
+		return cfg, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("config read: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(b, &cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &cfg)
+	case ".toml":
+		// TOML is decoded generically then passed through JSON so
+		// the forwarders keep the same schema as everywhere else
+		var m map[string]interface{}
+		if _, err = toml.Decode(string(b), &m); err != nil {
+			break
+		}
+		if b, err = json.Marshal(m); err != nil {
+			break
+		}
+		err = json.Unmarshal(b, &cfg)
+	default:
+		return cfg, fmt.Errorf("config %s: unknown file type, use .json, .yaml or .toml", path)
+	}
+
+	if err != nil {
+		return cfg, fmt.Errorf("config %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// env overrides the config with any PUBKEMAIL_* environment variables. The
+// forwarders use PUBKEMAIL_FWD_<NAME>=<json> and the addresses use a comma
+// separated list of <wif>[=<forwarder name>] in PUBKEMAIL_WIFS.
+func (cfg *config) env() error {
+	if v, ok := os.LookupEnv(envPrefix + "WEB_PORT"); ok {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("env %sWEB_PORT: %v", envPrefix, err)
+		}
+		cfg.WebPort = &port
+	}
+
+	for name, val := range map[string]*string{
+		"AFTER":        &cfg.After,
+		"DATA_DIR":     &cfg.DataDir,
+		"KEY_MANIFEST": &cfg.KeyManifest,
+		"SERVER_KEYS":  &cfg.ServerKeys,
+		"AGENT":        &cfg.Agent,
+		"POLL_NEXT":    &cfg.Poll.Next,
+		"POLL_WAIT":    &cfg.Poll.Wait,
+		"POLL_RESET":   &cfg.Poll.Reset,
+		"API_RSS":      &cfg.API.RSS,
+		"API_CONTENT":  &cfg.API.Content,
+		"API_SHARED":   &cfg.API.Shared,
+	} {
+		if v, ok := os.LookupEnv(envPrefix + name); ok {
+			*val = v
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix+"FWD_") {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		name := fwdKey(kv[len(envPrefix+"FWD_"):i])
+		if cfg.Forwarders == nil {
+			cfg.Forwarders = make(map[string]json.RawMessage)
+		}
+		cfg.Forwarders[name] = json.RawMessage(kv[i+1:])
+	}
+
+	if v, ok := os.LookupEnv(envPrefix + "WIFS"); ok {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			var addr configAddr
+			if i := strings.Index(s, "="); i > -1 {
+				addr.WIF, addr.FwdTo = s[:i], s[i+1:]
+			} else {
+				addr.WIF = s
+			}
+			cfg.Addresses = append(cfg.Addresses, addr)
+		}
+	}
+
+	return nil
+}
+
+// opts returns the config as functional options, the forwarders are added
+// before the addresses so that the addresses can be assigned to them
+func (cfg config) opts() (opts []commonOptFunc, err error) {
+	for _, poll := range []struct {
+		val string
+		dur func(*common) *time.Duration
+	}{
+		{cfg.Poll.Next, func(c *common) *time.Duration { return &c.term.check.intervalNextDuration }},
+		{cfg.Poll.Wait, func(c *common) *time.Duration { return &c.term.check.intervalWaitDuration }},
+		{cfg.Poll.Reset, func(c *common) *time.Duration { return &c.term.check.intervalResetDuration }},
+	} {
+		if poll.val == "" {
+			continue
+		}
+		d, err := time.ParseDuration(poll.val)
+		if err != nil {
+			return nil, fmt.Errorf("config poll interval: %v", err)
+		}
+		dur := poll.dur
+		opts = append(opts, func(c *common) {
+			c.term.check.m.Lock()
+			*dur(c) = d
+			c.term.check.m.Unlock()
+		})
+	}
+
+	for name, fwdJSON := range cfg.Forwarders {
+		name, fwdJSONText := name, string(fwdJSON)
+		if _, _, err := fwdEmailFromJSON(fwdJSONText); err != nil {
+			return nil, fmt.Errorf("config forwarder %q: %v", name, err)
+		}
+		opts = append(opts, func(c *common) {
+			c.provision = append(c.provision, func(c *common) { c.addFwd(name, fwdJSONText) })
+		})
+	}
+
+	for _, addr := range cfg.Addresses {
+		addr := addr
+		opts = append(opts, func(c *common) {
+			c.provision = append(c.provision, func(c *common) {
+				err := c.addSecret(addr.WIF, addr.Passphrase, addr.Path, addr.Gap, fwdKey(addr.FwdTo))
+				log.OnErr(err).Warnf("config address: %v", err)
+			})
+		})
+	}
+
+	for addr, label := range cfg.Labels {
+		addr, label := addr, label
+		opts = append(opts, func(c *common) {
+			c.provision = append(c.provision, func(c *common) { c.setLabel(addr, label) })
+		})
+	}
+
+	return opts, nil
+}
+
+// configFlags loads the config file and environment variables and copies
+// the values to any of the flags that were not set on the command line,
+// flags always win over the config. The string flags are keyed by name.
+func configFlags(path string, webPort *int, strFlags map[string]*string) ([]commonOptFunc, error) {
+	if v, ok := os.LookupEnv(envPrefix + "CONFIG"); ok && path == "" {
+		path = v
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cfg.env(); err != nil {
+		return nil, err
+	}
+
+	if cfg.WebPort != nil && !flag.CommandLine.Changed("web-port") {
+		*webPort = *cfg.WebPort
+	}
+
+	for name, val := range map[string]string{
+		"after":        cfg.After,
+		"data-dir":     cfg.DataDir,
+		"key-manifest": cfg.KeyManifest,
+		"server-keys":  cfg.ServerKeys,
+		"agent":        cfg.Agent,
+		"api-rss":      cfg.API.RSS,
+		"api-content":  cfg.API.Content,
+		"api-shared":   cfg.API.Shared,
+	} {
+		if p, ok := strFlags[name]; ok && val != "" && !flag.CommandLine.Changed(name) {
+			*p = val
+		}
+	}
+
+	opts, err := cfg.opts()
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, func(c *common) { c.daemon.configPath = path }), nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// debugFlags are the flags shared by the debugging subcommands, they
+// are what's needed to get the shared key of a WIF without the client
+type debugFlags struct {
+	dataDir        *string
+	serverKeysPath *string
+	apiShared      *string
+}
+
+// newDebugFlags adds the shared flags to the flag set
+func newDebugFlags(fs *flag.FlagSet) debugFlags {
+	return debugFlags{
+		dataDir:        fs.StringP("data-dir", "d", defaultDataDir(), "the directory the pubkemail shared public keys are pinned in"),
+		serverKeysPath: fs.StringP("server-keys", "", "", "a PEM file or directory of pubkemail shared public keys, so they never need to be fetched"),
+		apiShared:      fs.StringP("api-shared", "", apiURL.shared, "the base URL of the pubkemail shared public keys"),
+	}
+}
+
+// wif opens the server keys and returns the WIF, a watch-only key
+// can be used when only the shared key is needed
+func (f debugFlags) wif(secret string, watchOnly bool) (WIF, error) {
+	apiURL.shared = strings.TrimSuffix(*f.apiShared, "/")
+	if err := openServerKeys(filepath.Join(*f.dataDir, defaultServerKeysName), "", *f.serverKeysPath); err != nil {
+		return WIF{}, err
+	}
+
+	secret = strings.TrimSpace(secret)
+	if watchOnly && isWatchOnlyKey(secret) {
+		return decodeWatchOnly(secret)
+	}
+	return unmarshalWIF(secret)
+}
+
+// checkLink is the check-link subcommand, it prints whether a feed link is
+// for the WIF and the content hash the message would be downloaded with
+func checkLink(args []string) {
+	fs := flag.NewFlagSet("check-link", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("usage: pubkemail check-link <wif|address:shared key> <feed link> [flags]")
+		fs.PrintDefaults()
+	}
+	df := newDebugFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	wif, err := df.wif(fs.Arg(0), true)
+	if err != nil {
+		fmt.Printf("check-link: %v\n", err)
+		os.Exit(1)
+	}
+
+	u, err := url.Parse(fs.Arg(1))
+	if err != nil {
+		fmt.Printf("check-link: feed link: %v\n", err)
+		os.Exit(1)
+	}
+
+	q := u.Query()
+	fmt.Printf("address:      %s\n", strings.Join(wif.addrs(), ", "))
+	fmt.Printf("server key:   %s\n", wif.sharedFrom)
+	fmt.Printf("check:        %s\n", q.Get("check"))
+	fmt.Printf("sha-256:      %s\n", q.Get("hash"))
+	if ts, err := strconv.ParseInt(q.Get("ts"), 10, 64); err == nil {
+		fmt.Printf("timestamp:    %s (%d)\n", time.Unix(0, ts).UTC().Format(time.RFC3339Nano), ts)
+	} else {
+		fmt.Printf("timestamp:    %q is invalid\n", q.Get("ts"))
+	}
+
+	contentHash, ok := checkMetaLink(wif, q.Get("check"), q.Get("hash"), q.Get("ts"))
+	if !ok {
+		fmt.Println("match:        no, the link is not for this address")
+		os.Exit(2)
+	}
+	fmt.Println("match:        yes")
+	fmt.Printf("content hash: %s\n", contentHash)
+	fmt.Printf("content URL:  %s/v1/%s\n", apiURL.content, contentHash)
+}
+
+// decrypt is the decrypt subcommand, it writes the decrypted RFC 5322
+// message to stdout from a saved content file or a content hash that's
+// downloaded. The verification result is written to stderr.
+func decrypt(args []string) {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("usage: pubkemail decrypt <wif> <file|content hash> --ts <timestamp> [flags]")
+		fs.PrintDefaults()
+	}
+	df := newDebugFlags(fs)
+	tsStr := fs.StringP("ts", "t", "", "the timestamp from the feed link, as nanoseconds or RFC 3339")
+	sum := fs.StringP("sum", "", "", "the SHA-256 from the feed link (the hash parameter) to verify the content with")
+	apiContent := fs.StringP("api-content", "", apiURL.content, "the base URL of the pubkemail encrypted content")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 || *tsStr == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	ts, err := parseDebugTS(*tsStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decrypt: %v\n", err)
+		os.Exit(1)
+	}
+
+	wif, err := df.wif(fs.Arg(0), false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decrypt: %v\n", err)
+		os.Exit(1)
+	}
+
+	// a file that exists is a saved blob, anything else is a content hash
+	var content []byte
+	if _, serr := os.Stat(fs.Arg(1)); serr == nil {
+		content, err = ioutil.ReadFile(fs.Arg(1))
+	} else {
+		apiURL.content = strings.TrimSuffix(*apiContent, "/")
+		content, err = fetchContent(context.Background(), fs.Arg(1))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decrypt: %v\n", err)
+		os.Exit(1)
+	}
+
+	// saved files often end with a newline, it's only trimmed for decrypting
+	trimmed := bytes.TrimSpace(content)
+	body, sig, err := decryptContent(wif.priKey, wif.serverKey, bytes.NewReader(trimmed), ts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decrypt: %v\n", err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(body)
+
+	// the hash is checked against the bytes exactly as they were read, and
+	// then against the trimmed bytes in case the newline was added on saving
+	var msg Message
+	hashErr, matched := verifyContent(content, *sum), "content as it was read"
+	if hashErr != nil && *sum != "" && len(trimmed) != len(content) {
+		if verifyContent(trimmed, *sum) == nil {
+			hashErr, matched = nil, "trimmed content"
+		}
+	}
+	msg.verify(hashErr, sig)
+	fmt.Fprintf(os.Stderr, "%s: %s\n", verifiedHeader, msg.Header.Get(verifiedHeader))
+	switch {
+	case *sum == "":
+		fmt.Fprintln(os.Stderr, "the content hash was not checked, use --sum")
+	case hashErr == nil:
+		fmt.Fprintf(os.Stderr, "the content hash matched the %s\n", matched)
+	}
+}
+
+// parseDebugTS parses a timestamp from a feed link or in RFC 3339
+func parseDebugTS(s string) (time.Time, error) {
+	if ns, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, ns), nil
+	}
+	ts, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return ts, fmt.Errorf("--ts must be nanoseconds or RFC 3339: %v", err)
+	}
+	return ts, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/njones/logger"
@@ -17,7 +18,18 @@ func flags() []commonOptFunc {
 	var webPortP = flag.IntP("web-port", "p", 18810, "the port to use for the webserver")
 	var logPortP = flag.IntP("log-port", "", 0, "the port to use for sending logs to")
 	var webPrefixP = flag.StringP("web-prefix", "", "dev", "the prefix to use for the webserver")
-	var afterDateP = flag.StringP("after", "a", "", "the <Year>-<Month>-<Day>T<Hour>:<Minute>:<Second> <Timezone> to forward emails after. The T and time after is optional, use a timezone such as UTC,PDT for example.")
+	var configP = flag.StringP("config", "c", "", "the YAML, JSON or TOML file with the forwarders, WIFs and settings to start with")
+	var dataDirP = flag.StringP("data-dir", "d", defaultDataDir(), "the directory that holds the encrypted keystore")
+	var headlessP = flag.BoolP("headless", "", false, "run without the terminal view, logging to stderr instead (for systemd, containers, etc.)")
+	var passFileP = flag.StringP("passphrase-file", "", "", "the file holding the keystore passphrase when running headless, PUBKEMAIL_PASSPHRASE can also be used")
+	var keyManifestP = flag.StringP("key-manifest", "", "", "a file of pubkemail shared public keys signed with the release key, these are used instead of fetching the keys")
+	var serverKeysP = flag.StringP("server-keys", "", "", "a PEM file or directory of pubkemail shared public keys, so they never need to be fetched (for air-gapped machines)")
+	var agentP = flag.StringP("agent", "", "", "the Unix socket of a pubkemail agent, the agent holds the WIFs so this process never sees the private keys")
+	var importPendingP = flag.StringP("import-pending", "", "", "a file from export-pending on a watch-only client, the messages are decrypted and forwarded")
+	var apiRSSP = flag.StringP("api-rss", "", apiURL.rss, "the base URL of the pubkemail RSS feed")
+	var apiContentP = flag.StringP("api-content", "", apiURL.content, "the base URL of the pubkemail encrypted content")
+	var apiSharedP = flag.StringP("api-shared", "", apiURL.shared, "the base URL of the pubkemail shared public keys")
+	var afterDateP = flag.StringP("after", "a", "", "the <Year>-<Month>-<Day>T<Hour>:<Minute>:<Second> <Timezone> to forward emails after. The T and time after is optional, use a timezone such as UTC,PDT for example. Without it each address picks up after the last email it delivered.")
 
 	flag.Parse()
 
@@ -37,6 +49,25 @@ func flags() []commonOptFunc {
 		os.Exit(0)
 	}
 
+	cfgOpts, err := configFlags(*configP, webPortP, map[string]*string{
+		"after":        afterDateP,
+		"data-dir":     dataDirP,
+		"key-manifest": keyManifestP,
+		"server-keys":  serverKeysP,
+		"agent":        agentP,
+		"api-rss":      apiRSSP,
+		"api-content":  apiContentP,
+		"api-shared":   apiSharedP,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	apiURL.rss = strings.TrimSuffix(*apiRSSP, "/")
+	apiURL.content = strings.TrimSuffix(*apiContentP, "/")
+	apiURL.shared = strings.TrimSuffix(*apiSharedP, "/")
+
 	afterDate, err := time.Parse("2006-01-02T15:04:05 MST", *afterDateP)
 	if err != nil {
 		if afterDate, err = time.Parse("2006-01-02", *afterDateP); err != nil {
@@ -44,10 +75,25 @@ func flags() []commonOptFunc {
 		}
 	}
 
-	return []commonOptFunc{
+	return append([]commonOptFunc{
 		func(c *common) { c.web.port = fmt.Sprintf(":%d", *webPortP) },
 		func(c *common) { c.web.randPrefix = *webPrefixP },
 		func(c *common) { c.term.check.afterDate = afterDate },
+		func(c *common) { c.dataDir = *dataDirP },
+		func(c *common) { c.keyManifest = *keyManifestP },
+		func(c *common) { c.serverKeysPath = *serverKeysP },
+		func(c *common) { c.pendingPath = *importPendingP },
+		func(c *common) {
+			if *agentP != "" {
+				c.agent = &agentClient{path: *agentP}
+			}
+		},
+		func(c *common) {
+			if c.daemon.headless = *headlessP; c.daemon.headless {
+				log = headlessLog()
+			}
+		},
+		func(c *common) { c.daemon.passphraseFile = *passFileP },
 		func(c *common) { c.web.useLocalFS = true },
-	}
+	}, cfgOpts...)
 }
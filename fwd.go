@@ -2,36 +2,53 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
-	"html/template"
-	"io"
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/mail"
 	"net/smtp"
 	"net/url"
+	"sort"
 	"strings"
+	"text/template"
+	"time"
 )
 
-// fwdEmailFunc is a function that sends email
-type fwdEmailFunc func(from, subject, body string, headers mail.Header, isTest bool) error
+// fwdEmailFunc is a function that sends email, the send is stopped if the context is done
+type fwdEmailFunc func(ctx context.Context, rcpt fwdRcpt, from, subject, body string, headers mail.Header, isTest bool) error
+
+// fwdRcpt is the pubkemail address that a forwarded message was sent to
+type fwdRcpt struct {
+	addr, label string
+}
 
 // fwdVia holds all of the JSON types that can be
 // decoded as pointers, only the ones filled in will
 // be valid
 type fwdVia struct {
-	*fwdViaSMTP    `json:"smtp,omitempty"`
-	*fwdViaHTTPAPI `json:"http-api,omitempty"`
+	SMTP    *fwdViaSMTP    `json:"smtp,omitempty"`
+	HTTPAPI *fwdViaHTTPAPI `json:"http-api,omitempty"`
+
+	// Preset is expanded into the http-api forwarder of a provider
+	Preset *fwdPreset `json:"preset,omitempty"`
+
+	// PGP encrypts the email to the recipient's key before it's sent
+	PGP *fwdPGP `json:"pgp,omitempty"`
 }
 
 // fwdViaSMTP is the JSON used for forwarding email via SMTP
 type fwdViaSMTP struct {
-	*fwdViaSMTPV1 `json:"v1,omitempty"`
+	V1 *fwdViaSMTPV1 `json:"v1,omitempty"`
+	V2 *fwdViaSMTPV2 `json:"v2,omitempty"`
 }
 
-// fwdViaSMTP is version 1
+// fwdViaSMTPV1 is version 1
 type fwdViaSMTPV1 struct {
 	To      []string `json:"to"`
 	User    *string  `json:"user,omitempty"`
@@ -55,12 +72,17 @@ type fwdHTTP interface {
 	Method() string
 	Headers() map[string]string
 	Parameters() map[string][]string
-	IsMultipart() bool
+	Body() string
+	JSON() json.RawMessage
+	Attachments() *fwdAttachments
+	SigV4() *fwdSigV4
+	TestEmail() fwdHTTPTest
 }
 
 // fwdViaHTTPAPI is the JSON used for forwarding email via an HTTP-API
 type fwdViaHTTPAPI struct {
-	*fwdViaHTTPAPIV1 `json:"v1,omitempty"`
+	V1 *fwdViaHTTPAPIV1 `json:"v1,omitempty"`
+	V2 *fwdViaHTTPAPIV2 `json:"v2,omitempty"`
 }
 
 // fwdViaHTTPAPIV1 is version 1
@@ -74,12 +96,28 @@ type fwdViaHTTPAPIV1 struct {
 	ParametersVals map[string][]string `json:"parameters,omitempty"`
 
 	// only for testing... and should be passed through all future versions
+	fwdHTTPTest
+}
+
+// fwdHTTPTest holds the values that replace the email when the forwarder is tested
+type fwdHTTPTest struct {
 	From    string `json:"from,omitempty"`
 	Subject string `json:"subject,omitempty"`
 	Text    string `json:"text,omitempty"`
 	HTML    string `json:"html,omitempty"`
 }
 
+// fwdTemplateData is what the http-api headers, parameters and json can use,
+// the headers are decoded so the values are UTF-8
+type fwdTemplateData struct {
+	From, Subject, Text, HTML string
+	To, Cc                    []string
+	Date, MessageID           string
+	Header                    map[string][]string // keyed by the canonical name, i.e. Reply-To
+	Address, Label            string              // the pubkemail address it was sent to
+	Attachments               []fwdAttachmentData
+}
+
 func (fwd *fwdViaHTTPAPIV1) To() []string                    { return fwd.ToVals }
 func (fwd *fwdViaHTTPAPIV1) User() *string                   { return fwd.UserVal }
 func (fwd *fwdViaHTTPAPIV1) Pass() *string                   { return fwd.PassVal }
@@ -87,27 +125,95 @@ func (fwd *fwdViaHTTPAPIV1) URL() string                     { return fwd.URLVal
 func (fwd *fwdViaHTTPAPIV1) Method() string                  { return fwd.MethodVal }
 func (fwd *fwdViaHTTPAPIV1) Headers() map[string]string      { return fwd.HeadersVals }
 func (fwd *fwdViaHTTPAPIV1) Parameters() map[string][]string { return fwd.ParametersVals }
+func (fwd *fwdViaHTTPAPIV1) Body() string                    { return fwdContentTypeBody(fwd.HeadersVals) }
+func (fwd *fwdViaHTTPAPIV1) JSON() json.RawMessage           { return nil }
+func (fwd *fwdViaHTTPAPIV1) Attachments() *fwdAttachments    { return nil }
+func (fwd *fwdViaHTTPAPIV1) SigV4() *fwdSigV4                { return nil }
+func (fwd *fwdViaHTTPAPIV1) TestEmail() fwdHTTPTest          { return fwd.fwdHTTPTest }
+
+// fwdEmailFromJSON decodes the forwarding JSON and returns the function that
+// will send email along with the kind of forwarder that was found
+func fwdEmailFromJSON(fwdJSONText string) (fwdEmail fwdEmailFunc, kind string, err error) {
+	var via fwdVia
+	if err = json.Unmarshal([]byte(fwdJSONText), &via); err != nil {
+		return nil, kind, fmt.Errorf("json unmarshal: %v", err)
+	}
+
+	if via.Preset != nil {
+		if via.HTTPAPI != nil || via.SMTP != nil {
+			return nil, kind, fmt.Errorf("a preset can't be used with an smtp or http-api forwarder")
+		}
+		if via.HTTPAPI, err = via.Preset.expand(); err != nil {
+			return nil, kind, err
+		}
+	}
+
+	switch {
+	case via.HTTPAPI != nil:
+		if err = via.HTTPAPI.load(); err != nil {
+			return nil, kind, err
+		}
+		// the wrapper to forward mails via HTTP API calls
+		fwdEmail = func(ctx context.Context, rcpt fwdRcpt, from, subject, body string, headers mail.Header, isTest bool) error {
+			return fwdHTTPAPIEmail(ctx, via, rcpt, from, subject, body, headers, isTest)
+		}
+		kind = "HTTP API"
+		if via.Preset != nil {
+			kind = fwdPresets[strings.ToLower(via.Preset.Name)].title + " " + kind
+		}
+	case via.SMTP != nil:
+		smtpVia, err := via.SMTP.load()
+		if err != nil {
+			return nil, kind, err
+		}
+		// the wrapper to forward mails via SMTP calls
+		fwdEmail = func(ctx context.Context, rcpt fwdRcpt, from, subject, body string, headers mail.Header, isTest bool) error {
+			return fwdSMTPEmail(ctx, via, smtpVia, from, subject, body, headers, isTest)
+		}
+		kind = "SMTP"
+	default:
+		return nil, kind, fmt.Errorf("no smtp, http-api or preset forwarder found")
+	}
+
+	if via.PGP != nil {
+		if err = via.PGP.load(); err != nil {
+			return nil, kind, err
+		}
+		kind += " (PGP)"
+	}
+
+	return fwdEmail, kind, nil
+}
 
-// fwdSMTPEmail is the function that sends email via SMTP if a SMTP version has been defined
-func fwdSMTPEmail(via fwdVia, from, subject, body string, headers mail.Header, isTest bool) (err error) {
+// fwdSMTPEmail is the function that sends email via SMTP if a SMTP version has been defined.
+// The message isn't decoded like it is for an HTTP-API, SMTP carries the MIME as it is
+// and the mail client decodes it, only the envelope needs the address of the From header.
+func fwdSMTPEmail(ctx context.Context, via fwdVia, smtpVia *fwdSMTP, from, subject, body string, headers mail.Header, isTest bool) (err error) {
 	if isTest {
-		if via.fwdViaSMTP.From != "" {
-			from = via.fwdViaSMTP.From
+		if smtpVia.from != "" {
+			from = smtpVia.from
 		}
-		if via.fwdViaSMTP.Subject != "" {
-			subject = via.fwdViaSMTP.Subject
+		if smtpVia.subject != "" {
+			subject = smtpVia.subject
 		}
-		if via.fwdViaSMTP.Body != "" {
-			body = via.fwdViaSMTP.Body
+		if smtpVia.body != "" {
+			body = smtpVia.body
 		}
 	}
 
-	var auth smtp.Auth
-	if via.fwdViaSMTP.User != nil && via.fwdViaSMTP.Pass != nil {
-		addr := strings.Split(via.fwdViaSMTP.Address, ":")[0]
-		auth = smtp.PlainAuth("", *via.fwdViaSMTP.User, *via.fwdViaSMTP.Pass, addr)
+	if via.PGP != nil {
+		if body, headers, err = via.PGP.wrap(body, headers); err != nil {
+			return err
+		}
 	}
 
+	err = smtpSendMail(ctx, smtpVia, fwdEnvelopeFrom(from), fwdMessage(body, headers))
+	return err
+}
+
+// fwdMessage returns the RFC 5322 message that is forwarded as is, by SMTP
+// or the raw body of an HTTP-API
+func fwdMessage(body string, headers mail.Header) []byte {
 	// sort the order of the headers so that to, from and subject are last
 	var keys []string
 	for k, _ := range headers {
@@ -126,82 +232,180 @@ func fwdSMTPEmail(via fwdVia, from, subject, body string, headers mail.Header, i
 	}
 
 	msg += "\r\n" + body
+	return []byte(msg)
+}
 
-	err = smtp.SendMail(via.fwdViaSMTP.Address, auth, from, via.fwdViaSMTP.To, []byte(msg))
-	return err
+// smtpSendMail works the same as smtp.SendMail with the TLS, auth and timeouts
+// of the forwarder, and the connection is closed if the context is done
+// before the mail is sent
+func smtpSendMail(ctx context.Context, s *fwdSMTP, from string, msg []byte) error {
+	d := net.Dialer{Timeout: s.dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if s.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+	if s.tls == smtpTLSImplicit {
+		conn = tls.Client(conn, s.tlsConfig) // the handshake is done on the first read
+	}
+
+	sent := make(chan struct{})
+	defer close(sent)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-sent:
+		}
+	}()
+
+	host, _, _ := net.SplitHostPort(s.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.helo != "" {
+		if err = c.Hello(s.helo); err != nil {
+			return err
+		}
+	}
+
+	if s.tls == smtpTLSStartTLS || s.tls == smtpTLSOpportunistic {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err = c.StartTLS(s.tlsConfig); err != nil {
+				return err
+			}
+		} else if s.tls == smtpTLSStartTLS {
+			return fmt.Errorf("smtp: server doesn't support STARTTLS")
+		}
+	}
+	if s.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("smtp: server doesn't support AUTH")
+		}
+		if err = c.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range s.to {
+		if err = c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
 }
 
 // fwdHTTPAPIEmailReq prepares a request to be sent by a HTTP API. It breaks the forwarding
 // email down to it's various parts then allows for them to be passed through a template
 // before passing the message on to be sent via HTTP API
-func fwdHTTPAPIEmailReq(via fwdVia, from, subject, body string, headers mail.Header, isTest bool) (*http.Request, error) {
+func fwdHTTPAPIEmailReq(via fwdVia, rcpt fwdRcpt, from, subject, body string, headers mail.Header, isTest bool) (*http.Request, error) {
 	var html string
 	var text = body
-	var useMultipart bool
+	var attachments []fwdAttachment
+	var m fwdMIME
 
+	api := via.HTTPAPI.version()
 	if isTest {
-		if via.fwdViaHTTPAPI.From != "" {
-			from = via.fwdViaHTTPAPI.From
+		test := api.TestEmail()
+		if test.From != "" {
+			from = test.From
 		}
-		if via.fwdViaHTTPAPI.Subject != "" {
-			subject = via.fwdViaHTTPAPI.Subject
+		if test.Subject != "" {
+			subject = test.Subject
 		}
-		if via.fwdViaHTTPAPI.Text != "" {
-			text = via.fwdViaHTTPAPI.Text
+		if test.Text != "" {
+			text = test.Text
 		}
-		if via.fwdViaHTTPAPI.HTML != "" {
-			html = via.fwdViaHTTPAPI.HTML
+		if test.HTML != "" {
+			html = test.HTML
 		}
 	} else {
-		contentType, params, _ := mime.ParseMediaType(headers.Get("Content-Type"))
-		if strings.ToLower(contentType) == "multipart/alternative" {
-			r := strings.NewReader(body)
-			if boundary, ok := params["boundary"]; ok {
-				buf := new(bytes.Buffer)
-				mr := multipart.NewReader(r, boundary)
-				for {
-					p, err := mr.NextPart()
-					if err == io.EOF {
-						break
-					}
-					if err != nil {
-						log.Warnf("mime: read next part: %v", err)
-						continue
-					}
-					_, err = buf.ReadFrom(p)
-					if err != nil {
-						log.Warnf("mime: read from: %v", err)
-						continue
-					}
-					headerContentType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
-					switch headerContentType {
-					case "text/plain":
-						text = buf.String()
-					case "text/html":
-						html = buf.String()
-					}
-					buf.Reset()
-				}
+		m = fwdParseMIME(from, subject, body, headers)
+		from, subject = m.from, m.subject
+		text, html, attachments = m.text, m.html, m.attachments
+	}
+
+	// only a multipart body can send the files, any that don't
+	// fit are noted in the text
+	var opts *fwdAttachments
+	if api.Body() == fwdBodyMultipart {
+		opts = api.Attachments()
+	}
+	send, attachmentData, notes := opts.apply(attachments)
+	text, html = fwdAttachmentNotes(text, html, notes)
+
+	// the raw body is the whole message, so it's sent as PGP/MIME like SMTP
+	var raw []byte
+	if api.Body() == fwdBodyRaw {
+		var err error
+		if via.PGP != nil {
+			if body, headers, err = via.PGP.wrap(body, headers); err != nil {
+				return nil, err
+			}
+		}
+		raw = fwdMessage(body, headers)
+	}
+
+	// HTTP APIs build their own MIME so the text is sent as inline PGP,
+	// there's no way to encrypt the HTML without sending it in the clear
+	if via.PGP != nil {
+		armored, err := via.PGP.encrypt([]byte(text))
+		if err != nil {
+			return nil, err
+		}
+		text, html = armored, ""
+
+		for i, att := range send {
+			if armored, err = via.PGP.encrypt(att.data); err != nil {
+				return nil, err
 			}
+			send[i] = fwdAttachment{Filename: att.Filename + ".asc", ContentType: "application/pgp-encrypted", data: []byte(armored)}
 		}
 	}
 
-	Data := struct {
-		From, Subject, Text, HTML string
-	}{
-		From:    from,
-		Subject: subject,
-		Text:    text,
-		HTML:    html,
+	Data := fwdTemplateData{
+		From:        from,
+		Subject:     subject,
+		Text:        text,
+		HTML:        html,
+		To:          m.to,
+		Cc:          m.cc,
+		Date:        m.date,
+		MessageID:   m.messageID,
+		Header:      m.header,
+		Label:       rcpt.label,
+		Attachments: attachmentData,
+	}
+	if rcpt.addr != "" {
+		Data.Address = rcpt.addr + "@pubkemail.com"
 	}
 
-	u, err := url.Parse(via.fwdViaHTTPAPI.URL())
+	u, err := url.Parse(api.URL())
 	if err != nil {
 		return nil, err
 	}
 
 	req := &http.Request{
-		Method:     strings.ToUpper(via.fwdViaHTTPAPI.Method()),
+		Method:     strings.ToUpper(api.Method()),
 		URL:        u,
 		Proto:      "HTTP/1.1",
 		ProtoMajor: 1,
@@ -210,83 +414,182 @@ func fwdHTTPAPIEmailReq(via fwdVia, from, subject, body string, headers mail.Hea
 		Host:       u.Host,
 	}
 
-	if via.fwdViaHTTPAPI.User() != nil && via.fwdViaHTTPAPI.Pass() != nil {
-		req.SetBasicAuth(*via.fwdViaHTTPAPI.User(), *via.fwdViaHTTPAPI.Pass())
+	if api.User() != nil && api.Pass() != nil {
+		req.SetBasicAuth(*api.User(), *api.Pass())
 	}
 
-	var buf = new(bytes.Buffer)
-	for k, v := range via.fwdViaHTTPAPI.Headers() {
-		if strings.ToLower(k) == "content-type" {
-			contentType, _, _ := mime.ParseMediaType(v)
-			useMultipart = contentType == "multipart/form-data"
-		}
-		buf.Reset()
-		tmpl, err := template.New(k).Parse(v)
-		if err != nil {
-			return nil, fmt.Errorf("header template parse: %v", err)
-		}
-		err = tmpl.Execute(buf, Data)
+	for k, v := range api.Headers() {
+		val, err := fwdTemplate(k, v, Data)
 		if err != nil {
-			return nil, fmt.Errorf("header template exec: %v", err)
+			return nil, fmt.Errorf("header template: %v", err)
 		}
-		req.Header.Add(k, buf.String())
+		req.Header.Add(k, val)
 	}
-	buf.Reset()
 
-	var w *multipart.Writer
-	var wb = new(bytes.Buffer)
-	if useMultipart {
-		w = multipart.NewWriter(wb)
-		req.Header.Set("Content-Type", w.FormDataContentType())
-	}
-	var q url.URL
-	for k, vv := range via.fwdViaHTTPAPI.Parameters() {
+	// the names are templates too, a parameter is dropped when its name is empty
+	q := make(url.Values)
+	for k, vv := range api.Parameters() {
+		name, err := fwdTemplate(k, k, Data)
+		if err != nil {
+			return nil, fmt.Errorf("param template: %v", err)
+		}
+		if name == "" {
+			continue
+		}
 		for _, v := range vv {
-			buf.Reset()
-			tmpl, err := template.New(k).Parse(v)
+			val, err := fwdTemplate(k, v, Data)
 			if err != nil {
-				return nil, fmt.Errorf("param template parse: %v", err)
+				return nil, fmt.Errorf("param template: %v", err)
 			}
-			err = tmpl.Execute(buf, Data)
-			if err != nil {
-				return nil, fmt.Errorf("param template exec: %v", err)
+			q.Add(name, val)
+		}
+	}
+
+	// the parameters that aren't in the body go in the query
+	var reqBody []byte
+	var contentType string
+	switch api.Body() {
+	case fwdBodyMultipart:
+		wb := new(bytes.Buffer)
+		w := multipart.NewWriter(wb)
+		for _, k := range sortedKeys(q) {
+			for _, v := range q[k] {
+				w.WriteField(k, v)
 			}
-			if useMultipart {
-				w.WriteField(k, buf.String())
-				continue
+		}
+		for _, att := range send {
+			if err = att.writeFile(w, opts.field(att.Inline)); err != nil {
+				return nil, fmt.Errorf("attachment: %v", err)
 			}
-			q.Query().Add(k, buf.String())
 		}
-	}
-	if useMultipart {
 		w.Close()
-		req.Body = ioutil.NopCloser(wb)
-	} else if via.fwdViaHTTPAPI.Method() == http.MethodGet {
-		req.URL.RawQuery = q.RawQuery
-	} else {
-		req.Body = ioutil.NopCloser(strings.NewReader(q.Query().Encode()))
+		reqBody, q = wb.Bytes(), nil
+		req.Header.Set("Content-Type", w.FormDataContentType()) // it has the boundary
+	case fwdBodyJSON:
+		if reqBody, err = fwdJSONBody(api.JSON(), Data); err != nil {
+			return nil, err
+		}
+		contentType = "application/json"
+	case fwdBodyRaw:
+		reqBody, contentType = raw, "message/rfc822"
+	default:
+		if req.Method != http.MethodGet {
+			reqBody, q = []byte(q.Encode()), nil
+			contentType = "application/x-www-form-urlencoded"
+		}
+	}
+
+	if len(q) > 0 {
+		query := req.URL.Query()
+		for k, vv := range q {
+			query[k] = append(query[k], vv...)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
+	if reqBody != nil {
+		req.Body, req.ContentLength = ioutil.NopCloser(bytes.NewReader(reqBody)), int64(len(reqBody))
+		if req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+	}
+
+	if sig := api.SigV4(); sig != nil {
+		sig.sign(req, reqBody, time.Now())
 	}
 
 	return req, nil
 }
 
+// fwdTemplateFuncs escape a value for where it's used, nothing is escaped
+// unless it's asked for. The html and urlquery funcs are built in.
+var fwdTemplateFuncs = template.FuncMap{
+	"json":   fwdTemplateJSON,
+	"header": fwdTemplateHeader,
+}
+
+// fwdTemplateJSON returns the value as JSON, strings are quoted
+// so it's used as {{ .Subject | json }} without quotes around it
+func fwdTemplateJSON(v interface{}) (string, error) {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// fwdTemplateHeader makes the value safe for a header, the line breaks
+// are removed so no header can be added and anything that isn't ASCII
+// is RFC 2047 encoded
+func fwdTemplateHeader(v string) string {
+	v = strings.Join(strings.Fields(v), " ")
+	return mime.QEncoding.Encode("utf-8", v)
+}
+
+// fwdParseTemplate parses the text as a template with the escaping funcs
+func fwdParseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(fwdTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse: %v", err)
+	}
+	return tmpl, nil
+}
+
+// fwdTemplate executes the text as a template with the data
+func fwdTemplate(name, text string, data interface{}) (string, error) {
+	tmpl, err := fwdParseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if err = tmpl.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("exec: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// sortedKeys returns the keys of the values in order, so the
+// fields of a multipart body are always in the same order
+func sortedKeys(values url.Values) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // fwdHTTPAPIEmail is the function that sends email via HTTP-API if a HTTP-API version has been defined
-func fwdHTTPAPIEmail(via fwdVia, from, subject, body string, headers mail.Header, isTest bool) (err error) {
+func fwdHTTPAPIEmail(ctx context.Context, via fwdVia, rcpt fwdRcpt, from, subject, body string, headers mail.Header, isTest bool) (err error) {
 	var client http.Client
 
-	req, err := fwdHTTPAPIEmailReq(via, from, subject, body, headers, isTest)
+	req, err := fwdHTTPAPIEmailReq(via, rcpt, from, subject, body, headers, isTest)
 	if err != nil {
 		return err
 	}
 
-	resp, err := client.Do(req)
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("invalid response code: %d", resp.StatusCode)
+	if resp.StatusCode/100 != 2 {
+		return fwdHTTPStatusError{code: resp.StatusCode}
 	}
 
 	return nil
 }
+
+// fwdHTTPStatusError is returned when the HTTP-API doesn't answer with a 2xx
+// status, the outbox uses the code to tell if it's worth retrying
+type fwdHTTPStatusError struct {
+	code int
+}
+
+func (e fwdHTTPStatusError) Error() string {
+	return fmt.Sprintf("invalid response code: %d", e.code)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the body encodings of an http-api forwarder
+const (
+	fwdBodyForm      = "form"      // the parameters urlencoded, or in the query of a GET
+	fwdBodyMultipart = "multipart" // the parameters as multipart/form-data fields
+	fwdBodyJSON      = "json"      // the templated json document
+	fwdBodyRaw       = "raw"       // the RFC 5322 message as message/rfc822
+)
+
+// fwdViaHTTPAPIV2 is version 2, it adds the body setting so the
+// parameters aren't limited to multipart/form-data
+type fwdViaHTTPAPIV2 struct {
+	fwdViaHTTPAPIV1
+
+	BodyVal        string          `json:"body,omitempty"`        // form, multipart, json or raw
+	JSONVal        json.RawMessage `json:"json,omitempty"`        // every string in it is a template
+	AttachmentsVal *fwdAttachments `json:"attachments,omitempty"` // how a multipart body sends files
+	SigV4Val       *fwdSigV4       `json:"sigv4,omitempty"`       // signs the request for AWS APIs
+}
+
+func (fwd *fwdViaHTTPAPIV2) JSON() json.RawMessage { return fwd.JSONVal }
+func (fwd *fwdViaHTTPAPIV2) SigV4() *fwdSigV4      { return fwd.SigV4Val }
+func (fwd *fwdViaHTTPAPIV2) Attachments() *fwdAttachments {
+	if fwd.AttachmentsVal == nil {
+		return &fwdAttachments{}
+	}
+	return fwd.AttachmentsVal
+}
+func (fwd *fwdViaHTTPAPIV2) Body() string {
+	if fwd.BodyVal == "" {
+		return fwd.fwdViaHTTPAPIV1.Body()
+	}
+	return strings.ToLower(fwd.BodyVal)
+}
+
+// version returns the newest version that is filled in
+func (fwd *fwdViaHTTPAPI) version() fwdHTTP {
+	switch {
+	case fwd.V2 != nil:
+		return fwd.V2
+	case fwd.V1 != nil:
+		return fwd.V1
+	}
+	return nil
+}
+
+// load checks the forwarder when it's added so that a bad
+// setting is reported then and not when mail arrives
+func (fwd *fwdViaHTTPAPI) load() error {
+	api := fwd.version()
+	if api == nil {
+		return fmt.Errorf("http-api: no v1 or v2 forwarder found")
+	}
+
+	switch api.Body() {
+	case fwdBodyForm, fwdBodyMultipart, fwdBodyRaw:
+	case fwdBodyJSON:
+		// only parsed, an index of a header that isn't there fails without a message
+		if _, err := fwdJSONBody(api.JSON(), nil); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("http-api body: %q is not form, multipart, json or raw", api.Body())
+	}
+
+	if sig := api.SigV4(); sig != nil {
+		if sig.AccessKey == "" || sig.SecretKey == "" || sig.Region == "" || sig.Service == "" {
+			return fmt.Errorf("http-api sigv4: needs an access-key, secret-key, region and service")
+		}
+	}
+
+	if attachments := api.Attachments(); attachments != nil {
+		return attachments.load()
+	}
+	return nil
+}
+
+// fwdContentTypeBody returns the body encoding that the Content-Type header asks for,
+// it's how version 1 picked multipart
+func fwdContentTypeBody(headers map[string]string) string {
+	for k, v := range headers {
+		if strings.ToLower(k) != "content-type" {
+			continue
+		}
+		if contentType, _, _ := mime.ParseMediaType(v); contentType == "multipart/form-data" {
+			return fwdBodyMultipart
+		}
+	}
+	return fwdBodyForm
+}
+
+// fwdJSONBody runs every string in the json document through a template and
+// encodes it again, so the values are always escaped for JSON
+func fwdJSONBody(doc json.RawMessage, data interface{}) ([]byte, error) {
+	if len(doc) == 0 {
+		return nil, fmt.Errorf("http-api json: the json body needs a json document")
+	}
+
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber() // keep numbers as they were written
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("http-api json: %v", err)
+	}
+
+	v, err := fwdJSONTemplate(v, data)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err = enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("http-api json: %v", err)
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+// fwdJSONTemplate walks the decoded json and executes the strings and
+// keys as templates, they are only parsed when there's no data
+func fwdJSONTemplate(v interface{}, data interface{}) (interface{}, error) {
+	var err error
+	switch val := v.(type) {
+	case string:
+		if data == nil {
+			if _, err = fwdParseTemplate("json", val); err != nil {
+				return nil, fmt.Errorf("http-api json template: %v", err)
+			}
+			return val, nil
+		}
+		s, err := fwdTemplate("json", val, data)
+		if err != nil {
+			return nil, fmt.Errorf("http-api json template: %v", err)
+		}
+		return s, nil
+	case map[string]interface{}:
+		// the keys are templates too, a member is
+		// dropped when its key is empty
+		members := make(map[string]interface{}, len(val))
+		for k, elem := range val {
+			key, err := fwdJSONTemplate(k, data)
+			if err != nil {
+				return nil, err
+			}
+			if key == "" {
+				continue
+			}
+			if members[key.(string)], err = fwdJSONTemplate(elem, data); err != nil {
+				return nil, err
+			}
+		}
+		return members, nil
+	case []interface{}:
+		for i := range val {
+			if val[i], err = fwdJSONTemplate(val[i], data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// fwdSigV4 signs the request with AWS Signature Version 4, it's
+// how the AWS APIs (i.e. SES) are authenticated
+type fwdSigV4 struct {
+	AccessKey string `json:"access-key"`
+	SecretKey string `json:"secret-key"`
+	Region    string `json:"region"`
+	Service   string `json:"service"` // i.e. ses
+}
+
+// sign adds the X-Amz-Date and Authorization headers, the host, Content-Type
+// and any X-Amz-* headers are signed along with the body
+func (s *fwdSigV4) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate, date := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.Host}
+	for k, vv := range req.Header {
+		k = strings.ToLower(k)
+		if k == "content-type" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.Join(strings.Fields(strings.Join(vv, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	canonHeaders := new(bytes.Buffer)
+	for _, k := range names {
+		fmt.Fprintf(canonHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonReq := strings.Join([]string{
+		req.Method,
+		path,
+		sigV4Query(req.URL.Query()),
+		canonHeaders.String(),
+		signedHeaders,
+		sigV4Hash(body),
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/" + s.Service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sigV4Hash([]byte(canonReq))
+
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{date, s.Region, s.Service, "aws4_request"} {
+		key = sigV4HMAC(key, part)
+	}
+	signature := hex.EncodeToString(sigV4HMAC(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// sigV4Query returns the query sorted by key and value with spaces as %20
+func sigV4Query(query url.Values) string {
+	var pairs []string
+	for k, vv := range query {
+		for _, v := range vv {
+			pairs = append(pairs, sigV4Escape(k)+"="+sigV4Escape(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func sigV4Escape(s string) string { return strings.Replace(url.QueryEscape(s), "+", "%20", -1) }
+
+func sigV4Hash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func sigV4HMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/njones/logger"
+)
+
+func TestMain(m *testing.M) {
+	log = logger.New().Suppress(logger.LevelPrint) // it's set up in main
+	os.Exit(m.Run())
+}
+
+// fwdTestRequest is what the test server received
+type fwdTestRequest struct {
+	method, path, query string
+	header              http.Header
+	body                []byte
+}
+
+// fwdTestServer records each request it's sent and answers with the status
+func fwdTestServer(t *testing.T, status int) (*httptest.Server, <-chan fwdTestRequest) {
+	reqs := make(chan fwdTestRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+		reqs <- fwdTestRequest{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery, header: r.Header, body: body}
+		w.WriteHeader(status)
+	}))
+	return srv, reqs
+}
+
+// fwdTestSend decodes the forwarder JSON with $URL replaced by the test server
+// URL and forwards a plain text message with it, returning what was received
+func fwdTestSend(t *testing.T, fwdJSON string) fwdTestRequest {
+	srv, reqs := fwdTestServer(t, http.StatusOK)
+	defer srv.Close()
+
+	fwdEmail, _, err := fwdEmailFromJSON(strings.Replace(fwdJSON, "$URL", srv.URL, -1))
+	if err != nil {
+		t.Fatalf("forwarder: %v", err)
+	}
+
+	headers := mail.Header{
+		"From":    {"alice@example.com"},
+		"To":      {"bob@example.com"},
+		"Subject": {"Hello & goodbye"},
+	}
+	rcpt := fwdRcpt{addr: "1BoatSLRHtKNngkdXEeobR76b53LETtpyT", label: "shop"}
+	if err = fwdEmail(context.Background(), rcpt, "alice@example.com", "Hello & goodbye", "Hi there", headers, false); err != nil {
+		t.Fatalf("forward: %v", err)
+	}
+	return <-reqs
+}
+
+func TestFwdHTTPAPIEmailForm(t *testing.T) {
+	tests := []struct {
+		name        string
+		fwdJSON     string
+		method      string
+		contentType string
+		query       url.Values
+		form        url.Values // the urlencoded body
+	}{
+		{
+			name: "v2 post",
+			fwdJSON: `{"http-api": {"v2": {"url": "$URL/send", "method": "post", "body": "form",
+				"parameters": {"subject": ["{{ .Subject }}"], "text": ["{{ .Text }}"], "to": ["{{ .Address }}"]}}}}`,
+			method:      http.MethodPost,
+			contentType: "application/x-www-form-urlencoded",
+			query:       url.Values{},
+			form: url.Values{
+				"subject": {"Hello & goodbye"},
+				"text":    {"Hi there"},
+				"to":      {"1BoatSLRHtKNngkdXEeobR76b53LETtpyT@pubkemail.com"},
+			},
+		},
+		{
+			name: "v2 get",
+			fwdJSON: `{"http-api": {"v2": {"url": "$URL/send?key=abc", "method": "GET", "body": "form",
+				"parameters": {"subject": ["{{ .Subject }}"], "{{ if .HTML }}html{{ end }}": ["{{ .HTML }}"]}}}}`,
+			method: http.MethodGet,
+			query:  url.Values{"key": {"abc"}, "subject": {"Hello & goodbye"}},
+			form:   url.Values{},
+		},
+		{
+			// version 1 dropped the parameters of a form that wasn't multipart
+			name: "v1 post",
+			fwdJSON: `{"http-api": {"v1": {"url": "$URL/send", "method": "POST",
+				"parameters": {"from": ["{{ .From }}"], "label": ["{{ .Label }}"]}}}}`,
+			method:      http.MethodPost,
+			contentType: "application/x-www-form-urlencoded",
+			query:       url.Values{},
+			form:        url.Values{"from": {"alice@example.com"}, "label": {"shop"}},
+		},
+		{
+			name: "v1 get",
+			fwdJSON: `{"http-api": {"v1": {"url": "$URL/send", "method": "GET",
+				"parameters": {"subject": ["{{ .Subject }}"]}}}}`,
+			method: http.MethodGet,
+			query:  url.Values{"subject": {"Hello & goodbye"}},
+			form:   url.Values{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := fwdTestSend(t, test.fwdJSON)
+
+			if got.method != test.method {
+				t.Errorf("method: got %s want %s", got.method, test.method)
+			}
+			if got.path != "/send" {
+				t.Errorf("path: got %s want /send", got.path)
+			}
+			if ct := got.header.Get("Content-Type"); ct != test.contentType {
+				t.Errorf("content type: got %q want %q", ct, test.contentType)
+			}
+
+			query, err := url.ParseQuery(got.query)
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			if !reflect.DeepEqual(query, test.query) {
+				t.Errorf("query: got %v want %v", query, test.query)
+			}
+
+			form, err := url.ParseQuery(string(got.body))
+			if err != nil {
+				t.Fatalf("body: %v", err)
+			}
+			if !reflect.DeepEqual(form, test.form) {
+				t.Errorf("body: got %v want %v", form, test.form)
+			}
+		})
+	}
+}
+
+func TestFwdHTTPAPIEmailMultipart(t *testing.T) {
+	tests := []struct {
+		name    string
+		fwdJSON string
+	}{
+		{
+			name: "v2",
+			fwdJSON: `{"http-api": {"v2": {"url": "$URL/send", "method": "POST", "body": "multipart",
+				"parameters": {"subject": ["{{ .Subject }}"], "text": ["{{ .Text }}"]}}}}`,
+		},
+		{
+			// version 1 picks multipart from the Content-Type header
+			name: "v1",
+			fwdJSON: `{"http-api": {"v1": {"url": "$URL/send", "method": "POST",
+				"headers": {"Content-Type": "multipart/form-data"},
+				"parameters": {"subject": ["{{ .Subject }}"], "text": ["{{ .Text }}"]}}}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := fwdTestSend(t, test.fwdJSON)
+
+			mediaType, params, err := mime.ParseMediaType(got.header.Get("Content-Type"))
+			if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+				t.Fatalf("content type: got %q", got.header.Get("Content-Type"))
+			}
+
+			form, err := multipart.NewReader(bytes.NewReader(got.body), params["boundary"]).ReadForm(1 << 20)
+			if err != nil {
+				t.Fatalf("multipart: %v", err)
+			}
+			want := map[string][]string{"subject": {"Hello & goodbye"}, "text": {"Hi there"}}
+			if !reflect.DeepEqual(form.Value, want) {
+				t.Errorf("fields: got %v want %v", form.Value, want)
+			}
+			if got.query != "" {
+				t.Errorf("query: got %q want none", got.query)
+			}
+		})
+	}
+}
+
+func TestFwdHTTPAPIEmailJSON(t *testing.T) {
+	got := fwdTestSend(t, `{"http-api": {"v2": {"url": "$URL/send", "method": "POST", "body": "json",
+		"parameters": {"key": ["abc"]},
+		"json": {"from": "{{ .From }}", "subject": "{{ .Subject }}", "text": "{{ .Text }}",
+			"to": ["{{ index .To 0 }}"], "{{ if .HTML }}html{{ end }}": "{{ .HTML }}", "count": 1}}}}`)
+
+	if ct := got.header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type: got %q want application/json", ct)
+	}
+	want := `{"count":1,"from":"alice@example.com","subject":"Hello & goodbye","text":"Hi there","to":["bob@example.com"]}`
+	if string(got.body) != want {
+		t.Errorf("body:\ngot  %s\nwant %s", got.body, want)
+	}
+	if got.query != "key=abc" {
+		t.Errorf("query: got %q want key=abc", got.query)
+	}
+}
+
+func TestFwdHTTPAPIEmailRaw(t *testing.T) {
+	got := fwdTestSend(t, `{"http-api": {"v2": {"url": "$URL/send", "method": "PUT", "body": "raw"}}}`)
+
+	if got.method != http.MethodPut {
+		t.Errorf("method: got %s want PUT", got.method)
+	}
+	if ct := got.header.Get("Content-Type"); ct != "message/rfc822" {
+		t.Errorf("content type: got %q want message/rfc822", ct)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(got.body))
+	if err != nil {
+		t.Fatalf("message: %v", err)
+	}
+	if subject := msg.Header.Get("Subject"); subject != "Hello & goodbye" {
+		t.Errorf("subject: got %q", subject)
+	}
+	if body, _ := ioutil.ReadAll(msg.Body); strings.TrimSpace(string(body)) != "Hi there" {
+		t.Errorf("body: got %q", body)
+	}
+}
+
+func TestFwdHTTPAPIEmailReq(t *testing.T) {
+	user, pass := "api", "secret"
+	via := fwdVia{HTTPAPI: &fwdViaHTTPAPI{V2: &fwdViaHTTPAPIV2{BodyVal: fwdBodyForm}}}
+	api := via.HTTPAPI.V2
+	api.URLVal, api.MethodVal, api.UserVal, api.PassVal = "https://api.example.com/v3/send", "post", &user, &pass
+	api.HeadersVals = map[string]string{"X-Subject": "{{ .Subject | header }}"}
+	api.ParametersVals = map[string][]string{"subject": {"{{ .Subject }}"}}
+
+	// a subject with a line break can't add a header
+	subject := "Hello\r\nBcc: eve@example.com"
+	req, err := fwdHTTPAPIEmailReq(via, fwdRcpt{}, "alice@example.com", subject, "Hi", mail.Header{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Method != http.MethodPost || req.URL.String() != "https://api.example.com/v3/send" {
+		t.Errorf("request: got %s %s", req.Method, req.URL)
+	}
+	if u, p, ok := req.BasicAuth(); !ok || u != user || p != pass {
+		t.Errorf("basic auth: got %q %q", u, p)
+	}
+	if got := req.Header.Get("X-Subject"); strings.ContainsAny(got, "\r\n") || got != "Hello Bcc: eve@example.com" {
+		t.Errorf("header: got %q want it on one line", got)
+	}
+	if got := req.Header.Get("Bcc"); got != "" {
+		t.Errorf("header: got a Bcc of %q", got)
+	}
+
+	body, _ := ioutil.ReadAll(req.Body)
+	want := url.Values{"subject": {subject}}.Encode()
+	if string(body) != want || req.ContentLength != int64(len(body)) {
+		t.Errorf("body: got %q (%d) want %q", body, req.ContentLength, want)
+	}
+}
+
+func TestFwdTemplateFuncs(t *testing.T) {
+	tests := []struct {
+		name, text string
+		data       interface{}
+		want       string
+	}{
+		{"json quotes", "{{ . | json }}", `say "hi"`, `"say \"hi\""`},
+		{"json newlines", "{{ . | json }}", "line 1\r\nline 2", `"line 1\r\nline 2"`},
+		{"json html", "{{ . | json }}", "<b>&</b>", `"<b>&</b>"`},
+		{"json list", "{{ . | json }}", []string{"a", "b"}, `["a","b"]`},
+		{"header crlf", "{{ . | header }}", "Hello\r\nBcc: eve@example.com", "Hello Bcc: eve@example.com"},
+		{"header spaces", "{{ . | header }}", "  Hello \t there\n", "Hello there"},
+		{"header non-ascii", "{{ . | header }}", "Grüße", "=?utf-8?q?Gr=C3=BC=C3=9Fe?="},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := fwdTemplate(test.name, test.text, test.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFwdHTTPAPIEmailStatus(t *testing.T) {
+	srv, reqs := fwdTestServer(t, http.StatusBadGateway)
+	defer srv.Close()
+
+	via := fwdVia{HTTPAPI: &fwdViaHTTPAPI{V1: &fwdViaHTTPAPIV1{URLVal: srv.URL, MethodVal: "POST"}}}
+	err := fwdHTTPAPIEmail(context.Background(), via, fwdRcpt{}, "alice@example.com", "Hello", "Hi", mail.Header{}, false)
+	<-reqs
+
+	if e, ok := err.(fwdHTTPStatusError); !ok || e.code != http.StatusBadGateway {
+		t.Fatalf("got %v want a %d status error", err, http.StatusBadGateway)
+	}
+	if !fwdTemporary(err) {
+		t.Errorf("a %d status should be retried", http.StatusBadGateway)
+	}
+}
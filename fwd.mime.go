@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// fwdMIMEMaxDepth is how deep multipart messages are walked, it
+// stops a message from nesting parts without end
+const fwdMIMEMaxDepth = 16
+
+// the defaults of the attachments of an http-api forwarder
+const (
+	fwdAttachmentField       = "attachment"
+	fwdAttachmentInlineField = "inline"
+	fwdAttachmentMaxSize     = 10 << 20
+	fwdAttachmentMaxTotal    = 25 << 20
+	fwdOversizeDrop          = "drop"
+	fwdOversizeLink          = "link"
+)
+
+// fwdAttachment is a part of a message that isn't the text or HTML body
+type fwdAttachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string // without the <>, it's what a cid: URL in the HTML points at
+	Inline      bool
+	data        []byte
+}
+
+// fwdAttachmentData is what the templates can see of an attachment
+type fwdAttachmentData struct {
+	Filename    string
+	ContentType string
+	ContentID   string
+	Inline      bool
+	Size        int
+	Sent        bool   // sent as a file part
+	URL         string // where an oversized file was linked
+}
+
+// fwdMIME is a message broken down into its headers, text, HTML and
+// attachments. It's decoded for the templates, the headers from RFC 2047
+// and the parts from their transfer encoding and charset, so everything
+// is UTF-8.
+type fwdMIME struct {
+	from, subject   string
+	to, cc          []string
+	date, messageID string
+	header          map[string][]string
+	text, html      string
+	attachments     []fwdAttachment
+}
+
+// fwdWordDecoder decodes RFC 2047 encoded-words in any charset that's known
+var fwdWordDecoder = &mime.WordDecoder{CharsetReader: fwdCharsetReader}
+
+// fwdParseMIME walks every part of the message. The first text/plain and
+// text/html parts that aren't attachments are the text and HTML, everything
+// else is an attachment.
+func fwdParseMIME(from, subject, body string, headers mail.Header) fwdMIME {
+	m := fwdMIME{
+		from:      fwdDecodeHeader(from),
+		subject:   fwdDecodeHeader(subject),
+		to:        fwdAddressList(headers.Get("To")),
+		cc:        fwdAddressList(headers.Get("Cc")),
+		date:      headers.Get("Date"),
+		messageID: strings.TrimSpace(headers.Get("Message-Id")),
+		header:    make(map[string][]string, len(headers)),
+	}
+	for k, vv := range headers {
+		for _, v := range vv {
+			k := textproto.CanonicalMIMEHeaderKey(k)
+			m.header[k] = append(m.header[k], fwdDecodeHeader(v))
+		}
+	}
+
+	m.walk(textproto.MIMEHeader(headers), strings.NewReader(body), 0)
+	if m.text == "" && m.html == "" {
+		m.text = body
+	}
+	return m
+}
+
+// fwdCharsetReader converts from the charset to UTF-8, the names are
+// looked up like a browser does so the common aliases all work
+func fwdCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unknown charset %q", charset)
+	}
+	if name, _ := htmlindex.Name(enc); name == "utf-8" {
+		return input, nil
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// fwdDecodeHeader decodes the RFC 2047 encoded-words in a header value,
+// it's left as is if it can't be decoded
+func fwdDecodeHeader(v string) string {
+	decoded, err := fwdWordDecoder.DecodeHeader(v)
+	if err != nil {
+		return v
+	}
+	return decoded
+}
+
+// fwdDecodeText converts text in the charset to UTF-8, it's left as is
+// if the charset isn't known
+func fwdDecodeText(b []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return string(b)
+	}
+
+	r, err := fwdCharsetReader(charset, bytes.NewReader(b))
+	if err == nil {
+		var text []byte
+		if text, err = ioutil.ReadAll(r); err == nil {
+			return string(text)
+		}
+	}
+	log.Warnf("mime: %v", err)
+	return string(b)
+}
+
+// fwdEnvelopeFrom returns the address of a From header for the SMTP
+// envelope, which can't have a display name
+func fwdEnvelopeFrom(from string) string {
+	addr, err := (&mail.AddressParser{WordDecoder: fwdWordDecoder}).Parse(from)
+	if err != nil {
+		return from
+	}
+	return addr.Address
+}
+
+// fwdAddressList returns the decoded addresses of an address header as
+// Name <address>, the header is split on commas if it can't be parsed
+func fwdAddressList(v string) (list []string) {
+	if strings.TrimSpace(v) == "" {
+		return nil
+	}
+
+	addrs, err := (&mail.AddressParser{WordDecoder: fwdWordDecoder}).ParseList(v)
+	if err != nil {
+		for _, addr := range strings.Split(fwdDecodeHeader(v), ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				list = append(list, addr)
+			}
+		}
+		return list
+	}
+
+	for _, addr := range addrs {
+		// String() would encode the name again
+		if addr.Name == "" {
+			list = append(list, addr.Address)
+			continue
+		}
+		list = append(list, fmt.Sprintf("%s <%s>", addr.Name, addr.Address))
+	}
+	return list
+}
+
+// walk adds the part to the message, multipart parts are walked down
+func (m *fwdMIME) walk(header textproto.MIMEHeader, r io.Reader, depth int) {
+	contentType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	contentType = strings.ToLower(contentType)
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	if strings.HasPrefix(contentType, "multipart/") && depth < fwdMIMEMaxDepth {
+		mr := multipart.NewReader(r, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Warnf("mime: read next part: %v", err)
+				return
+			}
+			m.walk(p.Header, p, depth+1)
+		}
+		return
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		log.Warnf("mime: read part: %v", err)
+		return
+	}
+
+	disposition, dparams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	disposition = strings.ToLower(disposition)
+	filename := dparams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if filename != "" {
+		filename = filepath.Base(fwdDecodeHeader(filename)) // some mailers encode it like a header
+	}
+
+	data, err := fwdDecodeTransfer(header.Get("Content-Transfer-Encoding"), b)
+	if err != nil {
+		log.Warnf("mime: decode %s: %v", contentType, err)
+		data = b
+	}
+
+	isAttachment := disposition == "attachment" || filename != ""
+	switch {
+	case contentType == "text/plain" && !isAttachment && m.text == "":
+		m.text = fwdDecodeText(data, params["charset"])
+		return
+	case contentType == "text/html" && !isAttachment && m.html == "":
+		m.html = fwdDecodeText(data, params["charset"])
+		return
+	}
+
+	cid := strings.Trim(strings.TrimSpace(header.Get("Content-ID")), "<>")
+	m.attachments = append(m.attachments, fwdAttachment{
+		Filename:    filename,
+		ContentType: contentType,
+		ContentID:   cid,
+		Inline:      disposition == "inline" || (disposition == "" && cid != ""),
+		data:        data,
+	})
+}
+
+// fwdDecodeTransfer undoes the Content-Transfer-Encoding of a part, the
+// multipart reader has already done it for quoted-printable parts
+func fwdDecodeTransfer(encoding string, b []byte) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(bytes.TrimSpace(b))))
+	case "quoted-printable":
+		return ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(b)))
+	}
+	return b, nil
+}
+
+// fwdAttachments is the JSON for sending the attachments of a message as file
+// parts of a multipart body. Files over the size caps are dropped or saved to
+// a directory (served by your own web server) and linked in the text.
+type fwdAttachments struct {
+	Field       string `json:"field,omitempty"`        // the field of attachments, default attachment
+	InlineField string `json:"inline-field,omitempty"` // the field of inline cid: images, default inline
+	MaxSize     int    `json:"max-size,omitempty"`     // the bytes of one file, default 10 MiB
+	MaxTotal    int    `json:"max-total,omitempty"`    // the bytes of all files, default 25 MiB
+	Oversize    string `json:"oversize,omitempty"`     // drop (the default) or link
+	LinkDir     string `json:"link-dir,omitempty"`     // where linked files are saved
+	LinkURL     string `json:"link-url,omitempty"`     // a template of the link with the saved .Name
+}
+
+// load checks the settings when the forwarder is added
+func (a *fwdAttachments) load() error {
+	switch strings.ToLower(a.Oversize) {
+	case "", fwdOversizeDrop:
+	case fwdOversizeLink:
+		if a.LinkDir == "" || a.LinkURL == "" {
+			return fmt.Errorf("http-api attachments: link needs a link-dir and link-url")
+		}
+		if _, err := fwdTemplate("link-url", a.LinkURL, struct{ Name string }{}); err != nil {
+			return fmt.Errorf("http-api attachments link-url: %v", err)
+		}
+	default:
+		return fmt.Errorf("http-api attachments: oversize %q is not drop or link", a.Oversize)
+	}
+	return nil
+}
+
+func (a *fwdAttachments) field(inline bool) string {
+	switch {
+	case inline && a.InlineField != "":
+		return a.InlineField
+	case inline:
+		return fwdAttachmentInlineField
+	case a.Field != "":
+		return a.Field
+	}
+	return fwdAttachmentField
+}
+
+func (a *fwdAttachments) maxSize() int {
+	if a.MaxSize > 0 {
+		return a.MaxSize
+	}
+	return fwdAttachmentMaxSize
+}
+
+func (a *fwdAttachments) maxTotal() int {
+	if a.MaxTotal > 0 {
+		return a.MaxTotal
+	}
+	return fwdAttachmentMaxTotal
+}
+
+// apply picks the attachments that fit under the caps and handles the rest,
+// a note about each file that wasn't sent is returned for the text. Without
+// settings the attachments are only described to the templates.
+func (a *fwdAttachments) apply(attachments []fwdAttachment) (send []fwdAttachment, data []fwdAttachmentData, notes []string) {
+	var total int
+	for _, att := range attachments {
+		d := fwdAttachmentData{
+			Filename:    att.Filename,
+			ContentType: att.ContentType,
+			ContentID:   att.ContentID,
+			Inline:      att.Inline,
+			Size:        len(att.data),
+		}
+
+		switch {
+		case a == nil:
+		case len(att.data) <= a.maxSize() && total+len(att.data) <= a.maxTotal():
+			total += len(att.data)
+			d.Sent = true
+			send = append(send, att)
+		case strings.ToLower(a.Oversize) == fwdOversizeLink:
+			url, err := a.link(att)
+			if err != nil {
+				log.Warnf("attachment link: %v", err)
+				notes = append(notes, fmt.Sprintf("[attachment %s (%d bytes) was too large to forward]", d.Filename, d.Size))
+				break
+			}
+			d.URL = url
+			notes = append(notes, fmt.Sprintf("[attachment %s (%d bytes) was too large to forward, it was saved at %s]", d.Filename, d.Size, url))
+		default:
+			notes = append(notes, fmt.Sprintf("[attachment %s (%d bytes) was too large to forward]", d.Filename, d.Size))
+		}
+		data = append(data, d)
+	}
+	return send, data, notes
+}
+
+// link saves the attachment in the link directory under a name made from
+// its hash, so the same file is saved once, and returns its URL
+func (a *fwdAttachments) link(att fwdAttachment) (string, error) {
+	sum := sha256.Sum256(att.data)
+	name := hex.EncodeToString(sum[:8])
+	if att.Filename != "" {
+		name += "-" + att.Filename
+	}
+
+	if err := os.MkdirAll(a.LinkDir, 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(a.LinkDir, name), att.data, 0644); err != nil {
+		return "", err
+	}
+	return fwdTemplate("link-url", a.LinkURL, struct{ Name string }{name})
+}
+
+// fwdAttachmentNotes adds the notes about attachments that weren't sent to the text and HTML
+func fwdAttachmentNotes(text, htmlBody string, notes []string) (string, string) {
+	if len(notes) == 0 {
+		return text, htmlBody
+	}
+
+	text += "\r\n\r\n" + strings.Join(notes, "\r\n")
+	if htmlBody != "" {
+		for _, note := range notes {
+			htmlBody += "<p>" + html.EscapeString(note) + "</p>"
+		}
+	}
+	return text, htmlBody
+}
+
+// writeFile adds the attachment to the multipart body as a file part, an
+// inline part is named by its Content-ID so cid: links still find it
+func (att fwdAttachment) writeFile(w *multipart.Writer, field string) error {
+	filename := att.Filename
+	if att.Inline && att.ContentID != "" {
+		filename = att.ContentID
+	}
+	if filename == "" {
+		filename = "attachment"
+	}
+
+	contentType := att.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quote.Replace(field), quote.Replace(filename))},
+		"Content-Type":        {contentType},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(att.data)
+	return err
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// fwdPGP is the JSON used to encrypt forwarded email to the recipient's own
+// OpenPGP key so that it's never in cleartext on the relays it passes through
+type fwdPGP struct {
+	Key      string  `json:"key,omitempty"`       // an armored public key
+	Keyring  string  `json:"keyring,omitempty"`   // the path of a local keyring, armored or binary
+	SignKey  string  `json:"sign-key,omitempty"`  // the path of a local armored secret key to sign with
+	SignPass *string `json:"sign-pass,omitempty"` // the passphrase of the secret key
+
+	to     openpgp.EntityList
+	signer *openpgp.Entity
+}
+
+// load reads the keys, it's done once when the forwarder is added so
+// that a bad key is reported then and not when mail arrives
+func (p *fwdPGP) load() error {
+	if p.Key != "" {
+		to, err := openpgp.ReadArmoredKeyRing(strings.NewReader(p.Key))
+		if err != nil {
+			return fmt.Errorf("pgp key: %v", err)
+		}
+		p.to = append(p.to, to...)
+	}
+
+	if p.Keyring != "" {
+		to, err := readKeyRingFile(p.Keyring)
+		if err != nil {
+			return fmt.Errorf("pgp keyring: %v", err)
+		}
+		p.to = append(p.to, to...)
+	}
+
+	if len(p.to) == 0 {
+		return fmt.Errorf("pgp: a key or keyring is needed")
+	}
+
+	if p.SignKey == "" {
+		return nil
+	}
+
+	keys, err := readKeyRingFile(p.SignKey)
+	if err != nil {
+		return fmt.Errorf("pgp sign key: %v", err)
+	}
+	if len(keys) == 0 || keys[0].PrivateKey == nil {
+		return fmt.Errorf("pgp sign key: no secret key found in %s", p.SignKey)
+	}
+
+	p.signer = keys[0]
+	if p.signer.PrivateKey.Encrypted {
+		if p.SignPass == nil {
+			return fmt.Errorf("pgp sign key: the key is encrypted, sign-pass is needed")
+		}
+		if err = p.signer.PrivateKey.Decrypt([]byte(*p.SignPass)); err != nil {
+			return fmt.Errorf("pgp sign key: %v", err)
+		}
+		for _, subkey := range p.signer.Subkeys {
+			if subkey.PrivateKey == nil || !subkey.PrivateKey.Encrypted {
+				continue
+			}
+			if err = subkey.PrivateKey.Decrypt([]byte(*p.SignPass)); err != nil {
+				return fmt.Errorf("pgp sign subkey: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// readKeyRingFile reads an armored or binary keyring from a file
+func readKeyRingFile(path string) (openpgp.EntityList, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("-----BEGIN")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(b))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(b))
+}
+
+// encrypt returns the message encrypted and signed (if there's a sign key)
+// as armored text
+func (p *fwdPGP) encrypt(msg []byte) (string, error) {
+	buf := new(bytes.Buffer)
+	aw, err := armor.Encode(buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return "", fmt.Errorf("pgp armor: %v", err)
+	}
+
+	w, err := openpgp.Encrypt(aw, p.to, p.signer, nil, &packet.Config{DefaultHash: crypto.SHA256})
+	if err != nil {
+		return "", fmt.Errorf("pgp encrypt: %v", err)
+	}
+	if _, err = w.Write(msg); err != nil {
+		return "", fmt.Errorf("pgp encrypt: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		return "", fmt.Errorf("pgp encrypt: %v", err)
+	}
+	if err = aw.Close(); err != nil {
+		return "", fmt.Errorf("pgp armor: %v", err)
+	}
+	return buf.String() + "\r\n", nil
+}
+
+// wrap returns the body and headers of the message as PGP/MIME (RFC 3156).
+// The Content-* headers and body are encrypted as the inner MIME entity,
+// everything else is kept on the outside so the mail can be delivered.
+func (p *fwdPGP) wrap(body string, headers mail.Header) (string, mail.Header, error) {
+	inner := new(bytes.Buffer)
+	outer := make(mail.Header)
+	for k, vv := range headers {
+		ck := textproto.CanonicalMIMEHeaderKey(k)
+		switch {
+		case ck == "Mime-Version":
+		case strings.HasPrefix(ck, "Content-"):
+			for _, v := range vv {
+				fmt.Fprintf(inner, "%s: %s\r\n", ck, v)
+			}
+		default:
+			outer[k] = vv
+		}
+	}
+	if headers.Get("Content-Type") == "" {
+		fmt.Fprint(inner, "Content-Type: text/plain; charset=utf-8\r\n")
+	}
+	fmt.Fprintf(inner, "\r\n%s", body)
+
+	armored, err := p.encrypt(inner.Bytes())
+	if err != nil {
+		return "", nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+	fmt.Fprint(buf, "This is an OpenPGP/MIME encrypted message (RFC 4880 and 3156)\r\n")
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {"application/pgp-encrypted"},
+		"Content-Description": {"PGP/MIME version identification"},
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("pgp mime: %v", err)
+	}
+	io.WriteString(part, "Version: 1\r\n")
+
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {`application/octet-stream; name="encrypted.asc"`},
+		"Content-Description": {"OpenPGP encrypted message"},
+		"Content-Disposition": {`inline; filename="encrypted.asc"`},
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("pgp mime: %v", err)
+	}
+	io.WriteString(part, armored)
+	mw.Close()
+
+	outer["Mime-Version"] = []string{"1.0"}
+	outer["Content-Type"] = []string{fmt.Sprintf(`multipart/encrypted; protocol="application/pgp-encrypted"; boundary="%s"`, mw.Boundary())}
+	return buf.String(), outer, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// fwdPreset is the JSON of a provider preset, it's expanded into an
+// http-api forwarder so only the account details need to be given
+type fwdPreset struct {
+	Name   string   `json:"name"`             // i.e. mailgun, see fwdPresets
+	Key    string   `json:"key"`              // the API key, or the access key ID for ses
+	Secret string   `json:"secret,omitempty"` // the secret key of mailjet and ses
+	Domain string   `json:"domain,omitempty"` // the sending domain
+	Region string   `json:"region,omitempty"` // eu for mailgun and sparkpost, the AWS region for ses
+	From   string   `json:"from,omitempty"`   // pubkemail@<domain> by default
+	To     []string `json:"to"`
+}
+
+// fwdPresetFunc builds the forwarder of a provider
+type fwdPresetFunc func(p fwdPreset) (*fwdViaHTTPAPIV2, error)
+
+// fwdPresets are the providers that can be used as a preset,
+// keyed by name with the title shown on the dashboard
+var fwdPresets = map[string]struct {
+	title  string
+	expand fwdPresetFunc
+}{
+	"mailgun":   {"Mailgun", fwdPresetMailgun},
+	"sendgrid":  {"SendGrid", fwdPresetSendGrid},
+	"postmark":  {"Postmark", fwdPresetPostmark},
+	"ses":       {"Amazon SES v2", fwdPresetSES},
+	"sparkpost": {"SparkPost", fwdPresetSparkPost},
+	"mailjet":   {"Mailjet", fwdPresetMailjet},
+	"resend":    {"Resend", fwdPresetResend},
+}
+
+// fwdPresetTitles returns the titles of the presets keyed by name for the dashboard
+func fwdPresetTitles() map[string]string {
+	titles := make(map[string]string, len(fwdPresets))
+	for name, preset := range fwdPresets {
+		titles[name] = preset.title
+	}
+	return titles
+}
+
+// expand checks the preset and returns the http-api forwarder it stands for
+func (p *fwdPreset) expand() (*fwdViaHTTPAPI, error) {
+	preset, ok := fwdPresets[strings.ToLower(p.Name)]
+	if !ok {
+		return nil, fmt.Errorf("preset: %q is not a known provider", p.Name)
+	}
+
+	if p.Key == "" {
+		return nil, fmt.Errorf("preset %s: needs a key", p.Name)
+	}
+	if len(p.To) == 0 {
+		return nil, fmt.Errorf("preset %s: needs a recipient in to", p.Name)
+	}
+	if p.From == "" {
+		if p.Domain == "" {
+			return nil, fmt.Errorf("preset %s: needs a domain or from", p.Name)
+		}
+		p.From = "pubkemail@" + p.Domain
+	}
+
+	api, err := preset.expand(*p)
+	if err != nil {
+		return nil, fmt.Errorf("preset %s: %v", p.Name, err)
+	}
+	api.ToVals = p.To
+	return &fwdViaHTTPAPI{V2: api}, nil
+}
+
+// fwdPresetJSON returns the forwarder JSON of a preset, it's what the
+// dashboard saves so the account details can be changed later
+func fwdPresetJSON(p fwdPreset) (string, error) {
+	if _, err := p.expand(); err != nil {
+		return "", err
+	}
+	b, err := json.MarshalIndent(struct {
+		Preset fwdPreset `json:"preset"`
+	}{p}, "", "  ")
+	return string(b), err
+}
+
+// fwdLiteral returns a value that the templates will leave as is
+func fwdLiteral(s string) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	return "{{ " + strconv.Quote(s) + " }}"
+}
+
+func fwdLiterals(ss []string) []string {
+	lits := make([]string, len(ss))
+	for i, s := range ss {
+		lits[i] = fwdLiteral(s)
+	}
+	return lits
+}
+
+// fwdPresetAPI returns a forwarder that POSTs the json document
+func fwdPresetAPI(u string, headers map[string]string, doc interface{}) (*fwdViaHTTPAPIV2, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	api := &fwdViaHTTPAPIV2{BodyVal: fwdBodyJSON, JSONVal: b}
+	api.URLVal, api.MethodVal, api.HeadersVals = u, "POST", headers
+	return api, nil
+}
+
+// fwdPresetMailgun sends a multipart form, so the attachments are sent too
+func fwdPresetMailgun(p fwdPreset) (*fwdViaHTTPAPIV2, error) {
+	if p.Domain == "" {
+		return nil, fmt.Errorf("needs a domain")
+	}
+
+	host := "api.mailgun.net"
+	if strings.ToLower(p.Region) == "eu" {
+		host = "api.eu.mailgun.net"
+	}
+
+	user, pass := "api", p.Key
+	api := &fwdViaHTTPAPIV2{BodyVal: fwdBodyMultipart}
+	api.URLVal, api.MethodVal = "https://"+host+"/v3/"+url.PathEscape(p.Domain)+"/messages", "POST"
+	api.UserVal, api.PassVal = &user, &pass
+	api.ParametersVals = map[string][]string{
+		"from":                        {fwdLiteral(p.From)},
+		"to":                          fwdLiterals(p.To),
+		"subject":                     {"{{ .Subject }}"},
+		"text":                        {"{{ .Text }}"},
+		"{{ if .HTML }}html{{ end }}": {"{{ .HTML }}"},
+	}
+	return api, nil
+}
+
+// fwdPresetSendGrid sends the HTML when there is some, SendGrid
+// doesn't take an empty content value
+func fwdPresetSendGrid(p fwdPreset) (*fwdViaHTTPAPIV2, error) {
+	to := make([]map[string]string, len(p.To))
+	for i, addr := range p.To {
+		to[i] = map[string]string{"email": fwdLiteral(addr)}
+	}
+
+	return fwdPresetAPI("https://api.sendgrid.com/v3/mail/send",
+		map[string]string{"Authorization": "Bearer " + fwdLiteral(p.Key)},
+		map[string]interface{}{
+			"personalizations": []interface{}{map[string]interface{}{"to": to}},
+			"from":             map[string]string{"email": fwdLiteral(p.From)},
+			"subject":          "{{ .Subject }}",
+			"content": []interface{}{map[string]string{
+				"type":  "{{ if .HTML }}text/html{{ else }}text/plain{{ end }}",
+				"value": "{{ or .HTML .Text }}",
+			}},
+		})
+}
+
+func fwdPresetPostmark(p fwdPreset) (*fwdViaHTTPAPIV2, error) {
+	return fwdPresetAPI("https://api.postmarkapp.com/email",
+		map[string]string{"X-Postmark-Server-Token": fwdLiteral(p.Key), "Accept": "application/json"},
+		map[string]interface{}{
+			"From":                            fwdLiteral(p.From),
+			"To":                              fwdLiteral(strings.Join(p.To, ", ")),
+			"Subject":                         "{{ .Subject }}",
+			"TextBody":                        "{{ .Text }}",
+			"{{ if .HTML }}HtmlBody{{ end }}": "{{ .HTML }}",
+			"MessageStream":                   "outbound",
+		})
+}
+
+// fwdPresetSES uses the SES v2 API, the key is the access key ID and
+// the request is signed with the secret access key
+func fwdPresetSES(p fwdPreset) (*fwdViaHTTPAPIV2, error) {
+	if p.Secret == "" {
+		return nil, fmt.Errorf("needs the secret access key in secret")
+	}
+
+	region := p.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	content := func(data string) map[string]string { return map[string]string{"Data": data, "Charset": "UTF-8"} }
+	api, err := fwdPresetAPI("https://email."+url.PathEscape(region)+".amazonaws.com/v2/email/outbound-emails", nil,
+		map[string]interface{}{
+			"FromEmailAddress": fwdLiteral(p.From),
+			"Destination":      map[string]interface{}{"ToAddresses": fwdLiterals(p.To)},
+			"Content": map[string]interface{}{
+				"Simple": map[string]interface{}{
+					"Subject": content("{{ .Subject }}"),
+					"Body": map[string]interface{}{
+						"Text":                        content("{{ .Text }}"),
+						"{{ if .HTML }}Html{{ end }}": content("{{ .HTML }}"),
+					},
+				},
+			},
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	api.SigV4Val = &fwdSigV4{AccessKey: p.Key, SecretKey: p.Secret, Region: region, Service: "ses"}
+	return api, nil
+}
+
+func fwdPresetSparkPost(p fwdPreset) (*fwdViaHTTPAPIV2, error) {
+	host := "api.sparkpost.com"
+	if strings.ToLower(p.Region) == "eu" {
+		host = "api.eu.sparkpost.com"
+	}
+
+	recipients := make([]interface{}, len(p.To))
+	for i, addr := range p.To {
+		recipients[i] = map[string]interface{}{"address": map[string]string{"email": fwdLiteral(addr)}}
+	}
+
+	return fwdPresetAPI("https://"+host+"/api/v1/transmissions",
+		map[string]string{"Authorization": fwdLiteral(p.Key)},
+		map[string]interface{}{
+			"recipients": recipients,
+			"content": map[string]string{
+				"from":                        fwdLiteral(p.From),
+				"subject":                     "{{ .Subject }}",
+				"text":                        "{{ .Text }}",
+				"{{ if .HTML }}html{{ end }}": "{{ .HTML }}",
+			},
+		})
+}
+
+// fwdPresetMailjet uses the Send API v3.1, the key and secret are the basic auth
+func fwdPresetMailjet(p fwdPreset) (*fwdViaHTTPAPIV2, error) {
+	if p.Secret == "" {
+		return nil, fmt.Errorf("needs the secret key in secret")
+	}
+
+	to := make([]map[string]string, len(p.To))
+	for i, addr := range p.To {
+		to[i] = map[string]string{"Email": fwdLiteral(addr)}
+	}
+
+	api, err := fwdPresetAPI("https://api.mailjet.com/v3.1/send", nil,
+		map[string]interface{}{
+			"Messages": []interface{}{map[string]interface{}{
+				"From":                            map[string]string{"Email": fwdLiteral(p.From)},
+				"To":                              to,
+				"Subject":                         "{{ .Subject }}",
+				"TextPart":                        "{{ .Text }}",
+				"{{ if .HTML }}HTMLPart{{ end }}": "{{ .HTML }}",
+			}},
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	user, pass := p.Key, p.Secret
+	api.UserVal, api.PassVal = &user, &pass
+	return api, nil
+}
+
+func fwdPresetResend(p fwdPreset) (*fwdViaHTTPAPIV2, error) {
+	return fwdPresetAPI("https://api.resend.com/emails",
+		map[string]string{"Authorization": "Bearer " + fwdLiteral(p.Key)},
+		map[string]interface{}{
+			"from":                        fwdLiteral(p.From),
+			"to":                          fwdLiterals(p.To),
+			"subject":                     "{{ .Subject }}",
+			"text":                        "{{ .Text }}",
+			"{{ if .HTML }}html{{ end }}": "{{ .HTML }}",
+		})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+)
+
+var updateFixtures = flag.Bool("update", false, "record the preset fixtures in testdata again")
+
+// fwdPresetFixture is what a provider is sent for the test message, the
+// multipart fields or the json body are kept so they compare in any order
+type fwdPresetFixture struct {
+	Method string              `json:"method"`
+	Path   string              `json:"path"`
+	Header map[string]string   `json:"header"`
+	Fields map[string][]string `json:"fields,omitempty"`
+	JSON   json.RawMessage     `json:"json,omitempty"`
+}
+
+// the date and signature of a SigV4 Authorization change on every request
+var (
+	sigV4DateRe = regexp.MustCompile(`/\d{8}/`)
+	sigV4SigRe  = regexp.MustCompile(`Signature=[0-9a-f]{64}`)
+)
+
+// fwdPresetRecord turns a request into a fixture
+func fwdPresetRecord(t *testing.T, got fwdTestRequest) fwdPresetFixture {
+	fixture := fwdPresetFixture{Method: got.method, Path: got.path, Header: make(map[string]string)}
+	for _, k := range []string{"Authorization", "X-Postmark-Server-Token", "Accept"} {
+		if v := got.header.Get(k); v != "" {
+			fixture.Header[k] = v
+		}
+	}
+	if auth := fixture.Header["Authorization"]; got.header.Get("X-Amz-Date") != "" {
+		auth = sigV4DateRe.ReplaceAllString(auth, "/YYYYMMDD/")
+		fixture.Header["Authorization"] = sigV4SigRe.ReplaceAllString(auth, "Signature=...")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(got.header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("content type: %v", err)
+	}
+	fixture.Header["Content-Type"] = mediaType
+
+	switch mediaType {
+	case "multipart/form-data":
+		form, err := multipart.NewReader(bytes.NewReader(got.body), params["boundary"]).ReadForm(1 << 20)
+		if err != nil {
+			t.Fatalf("multipart: %v", err)
+		}
+		fixture.Fields = form.Value
+	case "application/json":
+		if !json.Valid(got.body) {
+			t.Fatalf("json body: %s is not valid", got.body)
+		}
+		fixture.JSON = got.body
+	default:
+		t.Fatalf("content type: %s is not multipart or json", mediaType)
+	}
+	return fixture
+}
+
+func TestFwdPresets(t *testing.T) {
+	names := make([]string, 0, len(fwdPresets))
+	for name := range fwdPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// a message with both parts, so the html is sent too
+	headers := mail.Header{
+		"From":         {"alice@example.com"},
+		"To":           {"bob@example.com"},
+		"Subject":      {"Hello"},
+		"Content-Type": {`multipart/alternative; boundary="b"`},
+	}
+	body := "--b\r\nContent-Type: text/plain\r\n\r\nHi there\r\n" +
+		"--b\r\nContent-Type: text/html\r\n\r\n<p>Hi there</p>\r\n--b--\r\n"
+
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			p := fwdPreset{Name: name, Key: "key-123", Secret: "secret-456", Domain: "example.com", To: []string{"bob@example.com"}}
+			fwd, err := p.expand()
+			if err != nil {
+				t.Fatalf("expand: %v", err)
+			}
+			if err = fwd.load(); err != nil {
+				t.Fatalf("load: %v", err)
+			}
+
+			srv, reqs := fwdTestServer(t, http.StatusOK)
+			defer srv.Close()
+
+			// the preset URLs are the providers, only the path is kept
+			u, err := url.Parse(fwd.V2.URLVal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fwd.V2.URLVal = srv.URL + u.EscapedPath()
+
+			err = fwdHTTPAPIEmail(context.Background(), fwdVia{HTTPAPI: fwd}, fwdRcpt{}, "alice@example.com", "Hello", body, headers, false)
+			if err != nil {
+				t.Fatalf("forward: %v", err)
+			}
+			got := fwdPresetRecord(t, <-reqs)
+
+			path := filepath.Join("testdata", "presets", name+".json")
+			if *updateFixtures {
+				buf := new(bytes.Buffer)
+				enc := json.NewEncoder(buf)
+				enc.SetEscapeHTML(false)
+				enc.SetIndent("", "  ")
+				if err = enc.Encode(got); err == nil {
+					err = ioutil.WriteFile(path, buf.Bytes(), 0644)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("fixture: %v", err)
+			}
+			var want fwdPresetFixture
+			if err = json.Unmarshal(b, &want); err != nil {
+				t.Fatalf("fixture: %v", err)
+			}
+
+			if got.Method != want.Method || got.Path != want.Path {
+				t.Errorf("request: got %s %s want %s %s", got.Method, got.Path, want.Method, want.Path)
+			}
+			if !reflect.DeepEqual(got.Header, want.Header) {
+				t.Errorf("headers: got %v want %v", got.Header, want.Header)
+			}
+			if !reflect.DeepEqual(got.Fields, want.Fields) {
+				t.Errorf("fields: got %v want %v", got.Fields, want.Fields)
+			}
+
+			var gotJSON, wantJSON interface{}
+			if len(want.JSON) > 0 {
+				json.Unmarshal(got.JSON, &gotJSON)
+				json.Unmarshal(want.JSON, &wantJSON)
+			}
+			if !reflect.DeepEqual(gotJSON, wantJSON) {
+				t.Errorf("json:\ngot  %s\nwant %s", got.JSON, want.JSON)
+			}
+		})
+	}
+}
+
+func TestFwdPresetErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		preset fwdPreset
+	}{
+		{"unknown", fwdPreset{Name: "pigeon", Key: "k", Domain: "example.com", To: []string{"bob@example.com"}}},
+		{"no key", fwdPreset{Name: "resend", Domain: "example.com", To: []string{"bob@example.com"}}},
+		{"no to", fwdPreset{Name: "resend", Key: "k", Domain: "example.com"}},
+		{"no from", fwdPreset{Name: "resend", Key: "k", To: []string{"bob@example.com"}}},
+		{"mailgun domain", fwdPreset{Name: "mailgun", Key: "k", From: "a@example.com", To: []string{"bob@example.com"}}},
+		{"ses secret", fwdPreset{Name: "ses", Key: "k", Domain: "example.com", To: []string{"bob@example.com"}}},
+		{"mailjet secret", fwdPreset{Name: "mailjet", Key: "k", Domain: "example.com", To: []string{"bob@example.com"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.preset.expand(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// TestFwdSigV4 signs the get-vanilla and post-vanilla requests of the
+// AWS Signature Version 4 test suite
+func TestFwdSigV4(t *testing.T) {
+	tests := []struct {
+		method, signature string
+	}{
+		{http.MethodGet, "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{http.MethodPost, "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+	}
+
+	sig := &fwdSigV4{
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:    "us-east-1",
+		Service:   "service",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, "https://example.amazonaws.com/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig.sign(req, nil, now)
+
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, Signature=" + test.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("authorization:\ngot  %s\nwant %s", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("x-amz-date: got %s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// the TLS modes of an SMTP forwarder
+const (
+	smtpTLSImplicit      = "implicit"      // TLS from the start, usually port 465
+	smtpTLSStartTLS      = "starttls"      // STARTTLS is required
+	smtpTLSOpportunistic = "opportunistic" // STARTTLS is used when it's offered
+	smtpTLSNone          = "none"
+)
+
+// fwdViaSMTPV2 is version 2, it adds the transport options that
+// providers need on top of version 1
+type fwdViaSMTPV2 struct {
+	fwdViaSMTPV1
+
+	TLS         string  `json:"tls,omitempty"`          // implicit, starttls, opportunistic or none
+	Auth        string  `json:"auth,omitempty"`         // plain, login, cram-md5, xoauth2 or none
+	Token       *string `json:"token,omitempty"`        // the XOAUTH2 access token, pass is used without it
+	CA          string  `json:"ca,omitempty"`           // the path of a PEM bundle to verify the server with
+	Pin         string  `json:"pin,omitempty"`          // the hex SHA-256 of the server's certificate
+	Helo        string  `json:"helo,omitempty"`         // the name sent with EHLO/HELO
+	DialTimeout string  `json:"dial-timeout,omitempty"` // i.e. 10s
+	Timeout     string  `json:"timeout,omitempty"`      // for the whole connection, i.e. 1m
+}
+
+// fwdSMTP is an SMTP forwarder with its version resolved, the TLS config
+// and auth are built once when the forwarder is added so that a bad
+// setting is reported then and not when mail arrives
+type fwdSMTP struct {
+	addr        string
+	helo        string
+	to          []string
+	auth        smtp.Auth
+	tls         string
+	tlsConfig   *tls.Config
+	dialTimeout time.Duration
+	timeout     time.Duration
+
+	// only for testing
+	from, subject, body string
+}
+
+// load returns the forwarder of the newest version that is filled in
+func (s *fwdViaSMTP) load() (*fwdSMTP, error) {
+	switch {
+	case s.V2 != nil:
+		return s.V2.load()
+	case s.V1 != nil:
+		return s.V1.load(), nil
+	}
+	return nil, fmt.Errorf("smtp: no v1 or v2 forwarder found")
+}
+
+// load keeps the version 1 behavior, STARTTLS when it's offered and
+// PLAIN auth when there's a user and pass
+func (v *fwdViaSMTPV1) load() *fwdSMTP {
+	host := strings.Split(v.Address, ":")[0]
+
+	s := &fwdSMTP{
+		addr:      v.Address,
+		to:        v.To,
+		tls:       smtpTLSOpportunistic,
+		tlsConfig: &tls.Config{ServerName: host},
+		from:      v.From,
+		subject:   v.Subject,
+		body:      v.Body,
+	}
+	if v.User != nil && v.Pass != nil {
+		s.auth = smtp.PlainAuth("", *v.User, *v.Pass, host)
+	}
+	return s
+}
+
+// load checks the version 2 options and builds the forwarder
+func (v *fwdViaSMTPV2) load() (*fwdSMTP, error) {
+	host, port, err := net.SplitHostPort(v.Address)
+	if err != nil {
+		return nil, fmt.Errorf("smtp addr: %v", err)
+	}
+
+	s := v.fwdViaSMTPV1.load()
+	s.helo = v.Helo
+
+	s.tls = strings.ToLower(v.TLS)
+	switch s.tls {
+	case "":
+		s.tls = smtpTLSOpportunistic
+		if port == "465" {
+			s.tls = smtpTLSImplicit
+		}
+	case smtpTLSImplicit, smtpTLSStartTLS, smtpTLSOpportunistic, smtpTLSNone:
+	default:
+		return nil, fmt.Errorf("smtp tls: %q is not implicit, starttls, opportunistic or none", v.TLS)
+	}
+
+	if v.CA != "" {
+		b, err := ioutil.ReadFile(v.CA)
+		if err != nil {
+			return nil, fmt.Errorf("smtp ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("smtp ca: no certificates found in %s", v.CA)
+		}
+		s.tlsConfig.RootCAs = pool
+	}
+
+	if v.Pin != "" {
+		pin, err := hex.DecodeString(strings.Replace(strings.TrimPrefix(strings.ToLower(v.Pin), "sha256:"), ":", "", -1))
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("smtp pin: must be the hex SHA-256 of the certificate")
+		}
+		// the pin replaces the chain and name checks, only that certificate is trusted
+		s.tlsConfig.InsecureSkipVerify = true
+		s.tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("smtp pin: the server sent no certificate")
+			}
+			if sum := sha256.Sum256(rawCerts[0]); !bytes.Equal(sum[:], pin) {
+				return fmt.Errorf("smtp pin: the server certificate %x doesn't match", sum)
+			}
+			return nil
+		}
+	}
+
+	if s.auth, err = v.loadAuth(host); err != nil {
+		return nil, err
+	}
+
+	if s.dialTimeout, err = parseTimeout(v.DialTimeout); err != nil {
+		return nil, fmt.Errorf("smtp dial-timeout: %v", err)
+	}
+	if s.timeout, err = parseTimeout(v.Timeout); err != nil {
+		return nil, fmt.Errorf("smtp timeout: %v", err)
+	}
+	return s, nil
+}
+
+// loadAuth returns the auth for the mechanism, plain is used
+// when it's not set and there's a user and pass
+func (v *fwdViaSMTPV2) loadAuth(host string) (smtp.Auth, error) {
+	var user, pass string
+	if v.User != nil {
+		user = *v.User
+	}
+	if v.Pass != nil {
+		pass = *v.Pass
+	}
+
+	mech := strings.ToLower(v.Auth)
+	switch mech {
+	case "none":
+		return nil, nil
+	case "":
+		if v.User == nil || v.Pass == nil {
+			return nil, nil
+		}
+		mech = "plain"
+	}
+
+	if v.User == nil {
+		return nil, fmt.Errorf("smtp auth: %s needs a user", mech)
+	}
+
+	switch mech {
+	case "plain":
+		return smtp.PlainAuth("", user, pass, host), nil
+	case "login":
+		return smtpLoginAuth{user: user, pass: pass}, nil
+	case "cram-md5":
+		return smtp.CRAMMD5Auth(user, pass), nil
+	case "xoauth2":
+		if v.Token != nil {
+			pass = *v.Token
+		}
+		if pass == "" {
+			return nil, fmt.Errorf("smtp auth: xoauth2 needs a token")
+		}
+		return smtpXOAuth2{user: user, token: pass}, nil
+	}
+	return nil, fmt.Errorf("smtp auth: %q is not plain, login, cram-md5, xoauth2 or none", v.Auth)
+}
+
+// parseTimeout parses a duration, an empty string is no timeout
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// smtpAuthTLS refuses to send credentials in the clear, except to
+// localhost, the same as smtp.PlainAuth
+func smtpAuthTLS(server *smtp.ServerInfo) error {
+	if server.TLS {
+		return nil
+	}
+	switch server.Name {
+	case "localhost", "127.0.0.1", "::1":
+		return nil
+	}
+	return errors.New("unencrypted connection")
+}
+
+// smtpLoginAuth is the LOGIN mechanism, it's not in net/smtp
+// but some providers only offer it
+type smtpLoginAuth struct {
+	user, pass string
+}
+
+func (a smtpLoginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := smtpAuthTLS(server); err != nil {
+		return "", nil, err
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a smtpLoginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	challenge := strings.ToLower(strings.TrimSpace(string(fromServer)))
+	switch {
+	case strings.HasPrefix(challenge, "user"):
+		return []byte(a.user), nil
+	case strings.HasPrefix(challenge, "pass"):
+		return []byte(a.pass), nil
+	}
+	return nil, fmt.Errorf("smtp login: unexpected challenge %q", fromServer)
+}
+
+// smtpXOAuth2 is the XOAUTH2 mechanism used by Gmail and Office 365
+// with an OAuth 2.0 access token
+type smtpXOAuth2 struct {
+	user, token string
+}
+
+func (a smtpXOAuth2) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := smtpAuthTLS(server); err != nil {
+		return "", nil, err
+	}
+	return "XOAUTH2", []byte("user=" + a.user + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a smtpXOAuth2) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// the server sent the error as JSON, an empty reply gets the error code
+		return []byte{}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// the derivation path and gap limit used when an xprv is added without them
+const (
+	defaultHDPath = "m/0"
+	defaultHDGap  = 20
+)
+
+// hdGroup is a window of addresses derived from a BIP32 extended private
+// key, all of the addresses in a group share the same forwarder. Like a
+// wallet, there are always gap unused addresses after the last one that
+// received mail.
+type hdGroup struct {
+	xprv  string
+	path  string
+	gap   uint32
+	next  uint32 // the number of addresses derived so far
+	used  uint32 // one past the highest index that received mail
+	fwdTo string
+	key   *hdkeychain.ExtendedKey // the key at the path
+}
+
+// hdIndex is the group and child index that an address was derived from
+type hdIndex struct {
+	xprv  string
+	index uint32
+}
+
+// commonHD holds the extended key groups and the addresses derived from them
+type commonHD struct {
+	m      sync.Mutex
+	groups map[string]*hdGroup
+	addrs  map[string]hdIndex
+	grow   map[string]bool // groups that need the window extended
+}
+
+// isXPrv returns true if the string looks like an extended private key
+func isXPrv(s string) bool {
+	for _, prefix := range []string{"xprv", "tprv"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHDPath parses a derivation path like m/44'/0'/0'/0 into child
+// indexes, hardened indexes can end in either ' or h
+func parseHDPath(path string) ([]uint32, error) {
+	var idxs []uint32
+	for i, part := range strings.Split(strings.TrimSpace(path), "/") {
+		if i == 0 && (part == "m" || part == "M") {
+			continue
+		}
+
+		var hardened uint32
+		if strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") {
+			part, hardened = part[:len(part)-1], hdkeychain.HardenedKeyStart
+		}
+
+		n, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("derivation path %q: %v", path, err)
+		}
+		idxs = append(idxs, uint32(n)+hardened)
+	}
+	return idxs, nil
+}
+
+// newHDGroup decodes the extended private key and derives the key at the path
+func newHDGroup(xprv, path string, gap int, fwdTo string) (*hdGroup, error) {
+	if path == "" {
+		path = defaultHDPath
+	}
+	if gap <= 0 {
+		gap = defaultHDGap
+	}
+
+	key, err := hdkeychain.NewKeyFromString(xprv)
+	if err != nil {
+		return nil, fmt.Errorf("extended key: %v", err)
+	}
+	if !key.IsPrivate() {
+		return nil, fmt.Errorf("extended key is not private")
+	}
+
+	idxs, err := parseHDPath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range idxs {
+		if key, err = key.Child(idx); err != nil {
+			return nil, fmt.Errorf("derive %s: %v", path, err)
+		}
+	}
+
+	return &hdGroup{xprv: xprv, path: path, gap: uint32(gap), fwdTo: fwdTo, key: key}, nil
+}
+
+// deriveWIF returns the WIF of the child at index i, with its shared key
+func (g *hdGroup) deriveWIF(i uint32) (WIF, error) {
+	child, err := g.key.Child(i)
+	if err != nil {
+		return WIF{}, fmt.Errorf("derive %s/%d: %v", g.path, i, err)
+	}
+
+	priKey, err := child.ECPrivKey()
+	if err != nil {
+		return WIF{}, fmt.Errorf("derive %s/%d: %v", g.path, i, err)
+	}
+
+	params := &chaincfg.TestNet3Params
+	if g.key.IsForNet(&chaincfg.MainNetParams) {
+		params = &chaincfg.MainNetParams
+	}
+
+	wif, err := btcutil.NewWIF(priKey, params, true)
+	if err != nil {
+		return WIF{}, fmt.Errorf("derive %s/%d: %v", g.path, i, err)
+	}
+
+	return unmarshalWIF(wif.String())
+}
+
+// addHDGroup adds an extended private key and watches the first next
+// addresses of the path, or at least gap of them. Adding a group that
+// is already watched only updates the forwarder.
+func (c *common) addHDGroup(xprv, path string, gap int, next uint32, fwdTo string) error {
+	c.hd.m.Lock()
+	g, ok := c.hd.groups[xprv]
+	c.hd.m.Unlock()
+
+	if ok {
+		if fwdTo != "" {
+			c.setHDGroupFwd(xprv, fwdTo)
+		}
+		// the saved window of a group the config also defines is kept
+		c.hd.m.Lock()
+		derived := g.next
+		c.hd.m.Unlock()
+		if next > derived {
+			return c.deriveHDGroup(g, next)
+		}
+		return nil
+	}
+
+	g, err := newHDGroup(xprv, path, gap, fwdTo)
+	if err != nil {
+		return err
+	}
+
+	c.hd.m.Lock()
+	c.hd.groups[xprv] = g
+	c.hd.m.Unlock()
+
+	if next < g.gap {
+		next = g.gap
+	}
+	return c.deriveHDGroup(g, next)
+}
+
+// deriveHDGroup derives and watches the addresses of the group up to
+// (but not including) the index upto
+func (c *common) deriveHDGroup(g *hdGroup, upto uint32) error {
+	for i := g.next; i < upto; i++ {
+		wif, err := g.deriveWIF(i)
+		if err != nil {
+			return err
+		}
+
+		c.hd.m.Lock()
+		c.hd.addrs[wif.addr] = hdIndex{xprv: g.xprv, index: i}
+		g.next = i + 1
+		c.hd.m.Unlock()
+
+		c.addAddr(wif, g.fwdTo)
+
+		group := fmt.Sprintf("%s...%s/%d", g.xprv[:8], g.path, i)
+		c.updateAddrDisplay(wif.addr, func(display *AddrDisplay) { display.Group = group })
+	}
+	return nil
+}
+
+// markHDUsed records that the address received mail, if it's from a group
+// and near the end of the window the group is queued to be extended
+func (c *common) markHDUsed(addr string) {
+	c.hd.m.Lock()
+	defer c.hd.m.Unlock()
+
+	idx, ok := c.hd.addrs[addr]
+	if !ok {
+		return
+	}
+
+	g := c.hd.groups[idx.xprv]
+	if idx.index+1 > g.used {
+		g.used = idx.index + 1
+	}
+	if g.used+g.gap > g.next {
+		c.hd.grow[idx.xprv] = true
+	}
+}
+
+// growHDGroups extends the window of every group that received mail
+// near the end of it. It's called from the feed reader so that new
+// addresses are only added between reading feed items.
+func (c *common) growHDGroups() {
+	c.hd.m.Lock()
+	grow := make(map[*hdGroup]uint32)
+	for xprv := range c.hd.grow {
+		g := c.hd.groups[xprv]
+		grow[g] = g.used + g.gap
+	}
+	c.hd.grow = make(map[string]bool)
+	c.hd.m.Unlock()
+
+	if len(grow) == 0 {
+		return
+	}
+
+	for g, upto := range grow {
+		err := c.deriveHDGroup(g, upto)
+		log.OnErr(err).Warnf("extend address group: %v", err)
+	}
+	c.saveKeystore()
+}
+
+// setHDGroupFwd changes the forwarder of every address in the group
+func (c *common) setHDGroupFwd(xprv, fwdTo string) {
+	c.hd.m.Lock()
+	g, ok := c.hd.groups[xprv]
+	if ok {
+		g.fwdTo = fwdTo
+	}
+	var addrs []string
+	for addr, idx := range c.hd.addrs {
+		if idx.xprv == xprv {
+			addrs = append(addrs, addr)
+		}
+	}
+	c.hd.m.Unlock()
+
+	for _, addr := range addrs {
+		if data, ok := c.addrDataOf(addr); ok {
+			c.addAddr(data.wif, fwdTo)
+		}
+	}
+}
+
+// hdGroupOf returns the extended key of the group the address is from
+func (c *common) hdGroupOf(addr string) (string, bool) {
+	c.hd.m.Lock()
+	defer c.hd.m.Unlock()
+	idx, ok := c.hd.addrs[addr]
+	return idx.xprv, ok
+}
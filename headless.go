@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/njones/logger"
+)
+
+// headless runs the client without the terminal view, everything that would
+// be shown in the top and bottom views is written as structured log lines. A
+// SIGHUP reloads the config file and systemd is notified when the client is
+// ready, reloading or stopping.
+func (c *common) headless() {
+	if err := c.headlessUnlockKeystore(); err != nil {
+		logfmt(os.Stderr, "level", "error", "msg", "keystore unlock", "err", err.Error())
+		os.Exit(1)
+	}
+
+	for portUpdate := range c.web.portUpdate {
+		c.web.port = portUpdate
+	}
+
+	logfmt(os.Stderr,
+		"level", "info",
+		"msg", "online",
+		"version", verSemVer,
+		"first_check", c.term.check.startTime,
+		"web", fmt.Sprintf("http://localhost%s/%s/", c.web.port, c.web.randPrefix),
+	)
+
+	go c.termReadFeed(c.ctx)
+
+	c.term.checkers.Add(2)
+	go func() {
+		defer c.term.checkers.Done()
+		c.importPending()
+	}()
+	go func() {
+		defer c.term.checkers.Done()
+		c.runOutbox()
+	}()
+	go sdWatchdog(c.ctx)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	err := sdNotify("READY=1")
+	log.OnErr(err).Warnf("sd_notify: %v", err)
+
+	for {
+		select {
+		case update := <-c.term.update.viewTop:
+			logfmt(os.Stderr, "level", "info", "view", "top", "last_check", update.lastCheckTime)
+		case update := <-c.term.update.viewBottom:
+			for _, line := range strings.Split(update, "\n") {
+				if fields := strings.Fields(line); len(fields) == 2 {
+					logfmt(os.Stderr, "level", "info", "view", "bottom", "currency", fields[0], "addr", fields[1])
+				}
+			}
+		case <-hup:
+			sdNotify("RELOADING=1")
+			if err := c.reloadConfig(); err != nil {
+				logfmt(os.Stderr, "level", "warn", "msg", "config reload", "err", err.Error())
+			} else {
+				logfmt(os.Stderr, "level", "info", "msg", "config reloaded", "config", c.daemon.configPath)
+			}
+			sdNotify("READY=1")
+		case <-c.ctx.Done():
+			sdNotify("STOPPING=1")
+			logfmt(os.Stderr, "level", "info", "msg", "stopping")
+			return
+		}
+	}
+}
+
+// headlessUnlockKeystore unlocks the keystore with the passphrase from the
+// --passphrase-file or the PUBKEMAIL_PASSPHRASE environment variable. Without
+// a passphrase the client runs with only what's in the config file.
+func (c *common) headlessUnlockKeystore() error {
+	pass, ok := os.LookupEnv(envPrefix + "PASSPHRASE")
+	os.Unsetenv(envPrefix + "PASSPHRASE") // so it's not passed on or left in /proc
+	if c.daemon.passphraseFile != "" {
+		b, err := ioutil.ReadFile(c.daemon.passphraseFile)
+		if err != nil {
+			return fmt.Errorf("read passphrase file: %v", err)
+		}
+		pass, ok = strings.TrimRight(string(b), "\r\n"), true
+	}
+
+	if !ok {
+		logfmt(os.Stderr, "level", "warn", "msg", "no keystore passphrase, nothing added through the web interface will be saved")
+		return nil
+	}
+	return c.loadKeystore([]byte(pass))
+}
+
+// reloadConfig reads the config file and environment again, adding
+// any new forwarders and addresses and updating the poll intervals
+func (c *common) reloadConfig() error {
+	cfg, err := loadConfig(c.daemon.configPath)
+	if err != nil {
+		return err
+	}
+	if err = cfg.env(); err != nil {
+		return err
+	}
+
+	opts, err := cfg.opts()
+	if err != nil {
+		return err
+	}
+
+	c.provision = nil
+	for _, opt := range opts {
+		opt(c)
+	}
+	for _, fn := range c.provision {
+		fn(c)
+	}
+
+	c.saveKeystore()
+	return nil
+}
+
+// headlessLog returns the logger for running headless, the warnings of the
+// feed, deliveries and forwarders are written as logfmt lines like the rest
+func headlessLog() logger.Logger {
+	return logger.New(logger.WithOutput(logfmtWriter{os.Stderr})).Suppress(logger.LevelPrint)
+}
+
+// logfmtWriter writes each line of the logger as a logfmt warning
+type logfmtWriter struct {
+	w io.Writer
+}
+
+func (lw logfmtWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			logfmt(lw.w, "level", "warn", "msg", line)
+		}
+	}
+	return len(p), nil
+}
+
+// logfmt writes a single log line of key=value pairs, values that are
+// empty or have spaces, equals, quotes or control characters (a newline
+// would split the line) are quoted
+func logfmt(w io.Writer, kv ...string) {
+	parts := []string{"time=" + time.Now().Format(time.RFC3339)}
+	for i := 0; i+1 < len(kv); i += 2 {
+		v := kv[i+1]
+		if v == "" || strings.IndexFunc(v, logfmtQuote) > -1 {
+			v = strconv.Quote(v)
+		}
+		parts = append(parts, kv[i]+"="+v)
+	}
+	fmt.Fprintln(w, strings.Join(parts, " "))
+}
+
+// logfmtQuote reports if a value with the rune needs to be quoted
+func logfmtQuote(r rune) bool {
+	return r == ' ' || r == '=' || r == '"' || unicode.IsControl(r)
+}
+
+// sdNotify sends the state to systemd when the client was started by
+// a Type=notify service, it does nothing if NOTIFY_SOCKET is not set
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:] // abstract namespace socket
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdog pings the systemd watchdog at half of WATCHDOG_USEC until the
+// context is done, it returns straight away if the watchdog is not enabled
+func sdWatchdog(ctx context.Context) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	interval := time.Duration(usec) * time.Microsecond / 2
+	for {
+		sleepCtx(ctx, interval)
+		if ctx.Err() != nil {
+			return
+		}
+		err := sdNotify("WATCHDOG=1")
+		log.OnErr(err).Warnf("sd_notify watchdog: %v", err)
+	}
+}
@@ -1,16 +1,16 @@
 package main
 
 import (
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/asn1"
-	"encoding/pem"
 	"fmt"
-	"io/ioutil"
 	"math/big"
-	"net/http"
+	"os/user"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -39,72 +39,43 @@ type ecPublicKey struct {
 	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:2"`
 }
 
-// remotePublicKey returns the pubkemail public key that coorosponds to the
-// public key of an address that has been submited. This is what the shared
-// key is based on.
-func remotePublicKey(x []byte) ([]byte, error) {
-	resp, err := http.Get(fmt.Sprintf("http://shared.pubkemail.com/public/key/%x", x))
-	if err != nil {
-		return nil, fmt.Errorf("http get public key: %v", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("http get status: %v", http.StatusText(resp.StatusCode))
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
+// sharedKey takes the bytes of a public key and private key and returns
+// the shared key based on both of them.
+func sharedKey(pubKey, priKey []byte) (shrKey []byte, err error) {
+	puX, puY, err := unmarshalPoint(pubKey)
 	if err != nil {
-		return nil, fmt.Errorf("http get read body: %v", err)
-	}
-
-	if len(body) == 0 {
-		return nil, fmt.Errorf("http get public key empty pem block")
+		return nil, err
 	}
 
-	block, rest := pem.Decode(body)
-	if len(rest) > 0 {
-		return nil, fmt.Errorf("http get public key pem block: extra data")
-	}
-
-	var ecPubKey = ecPublicKey{}
-	rum, err := asn1.Unmarshal(block.Bytes, &ecPubKey)
-	if err != nil {
-		return nil, fmt.Errorf("http get public key unmarshal: %v", err)
-	}
+	shX, shY := bitelliptic.S256().ScalarMult(puX, puY, priKey)
+	return elliptic.Marshal(bitelliptic.S256(), shX, shY), nil
+}
 
-	if len(rum) != 0 {
-		return nil, fmt.Errorf("http get public key unmarshal asn1: extra data")
+// unmarshalPoint returns the point of a compressed or uncompressed public key
+func unmarshalPoint(pubKey []byte) (puX, puY *big.Int, err error) {
+	if len(pubKey) == 0 {
+		return nil, nil, fmt.Errorf("the public key is invalid")
 	}
 
-	return ecPubKey.PublicKey.Bytes, nil
-}
-
-// sharedKey takes the bytes of a public key and private key and returns
-// the shared key based on both of them.
-func sharedKey(pubKey, priKey []byte) (shrKey []byte, err error) {
-	var puX, puY *big.Int
 	switch pubKey[0] {
 	case 0x02, 0x03:
 		puX, puY, err = eccpUnmarshal(bitelliptic.S256(), pubKey)
 		if err != nil {
 			log.Warnf("invalid unmarshal: %v", err)
-			return nil, fmt.Errorf("the public key is invalid")
+			return nil, nil, fmt.Errorf("the public key is invalid")
 		}
 	case 0x04:
 		puX, puY = elliptic.Unmarshal(bitelliptic.S256(), pubKey)
 	default:
 		log.Warnf("invalid public key type: 0x%x", pubKey[0])
-		return nil, fmt.Errorf("the public key is invalid")
+		return nil, nil, fmt.Errorf("the public key is invalid")
 	}
 
-	if !bitelliptic.S256().IsOnCurve(puX, puY) {
+	if puX == nil || !bitelliptic.S256().IsOnCurve(puX, puY) {
 		log.Warnf("the public key is invalid. Not on curve")
-		return nil, fmt.Errorf("the public key is invalid")
+		return nil, nil, fmt.Errorf("the public key is invalid")
 	}
-
-	shX, shY := bitelliptic.S256().ScalarMult(puX, puY, priKey)
-	return elliptic.Marshal(bitelliptic.S256(), shX, shY), nil
+	return puX, puY, nil
 }
 
 // eccpUnmarshal returns the compressed key public key pair
@@ -127,6 +98,68 @@ func eccpUnmarshal(curve elliptic.Curve, compBytes []byte) (x, y *big.Int, err e
 // a valid WIF, if so it will grab the shared key and
 // return a struct that contains all of the WIF details
 func unmarshalWIF(wifStr string) (w WIF, err error) {
+	w, err = decodeWIF(wifStr)
+	if err != nil {
+		return w, err
+	}
+
+	remPubKey, source, err := remotePublicKey(w.pubKey[len(w.pubKey)-1:])
+	if err != nil {
+		return w, fmt.Errorf("remote pubk: %v", err)
+	}
+	w.sharedFrom = fmt.Sprintf("%s (%s)", keyFingerprint(remPubKey), source)
+	w.serverKey = remPubKey
+
+	w.sharedKey, err = sharedKey(remPubKey, w.priKey)
+	if err != nil {
+		return w, err
+	}
+
+	return w, nil
+}
+
+// wifNetwork is the currency and address parameters for a WIF network byte
+type wifNetwork struct {
+	currency string
+	params   *chaincfg.Params
+}
+
+// wifNetworks maps the first byte of a WIF to its network, chaincfg only
+// knows about bitcoin so Litecoin and Dogecoin have their own param sets
+var wifNetworks = map[byte]wifNetwork{
+	0x80: {"BTC", &chaincfg.MainNetParams},
+	0xB0: {"LTC", &litecoinMainNetParams},
+	0x9E: {"XDG", &dogecoinMainNetParams},
+	0xEF: {"-T-", &chaincfg.TestNet3Params},
+	0xF1: {"-T-", &dogecoinTestNetParams},
+}
+
+// the Litecoin and Dogecoin params needed to encode addresses
+var (
+	litecoinMainNetParams = chaincfg.Params{
+		Name:             "litecoin-mainnet",
+		PubKeyHashAddrID: 0x30,
+		ScriptHashAddrID: 0x32,
+		PrivateKeyID:     0xB0,
+		Bech32HRPSegwit:  "ltc",
+	}
+	dogecoinMainNetParams = chaincfg.Params{
+		Name:             "dogecoin-mainnet",
+		PubKeyHashAddrID: 0x1E,
+		ScriptHashAddrID: 0x16,
+		PrivateKeyID:     0x9E,
+	}
+	dogecoinTestNetParams = chaincfg.Params{
+		Name:             "dogecoin-testnet",
+		PubKeyHashAddrID: 0x71,
+		ScriptHashAddrID: 0xC4,
+		PrivateKeyID:     0xF1,
+	}
+)
+
+// decodeWIF returns the WIF details that can be worked out without
+// contacting pubkemail, everything except the shared key
+func decodeWIF(wifStr string) (w WIF, err error) {
 	var wif *btcutil.WIF
 
 	b, err := base58.BitcoinEncoding.DecodeString(wifStr)
@@ -138,43 +171,72 @@ func unmarshalWIF(wifStr string) (w WIF, err error) {
 		return w, fmt.Errorf("wif is invalid")
 	}
 
+	network, ok := wifNetworks[b[0]]
+	if !ok {
+		return w, fmt.Errorf("wif network 0x%X is not supported", b[0])
+	}
+
 	wif, err = btcutil.DecodeWIF(wifStr)
 	if err != nil {
 		return w, err
 	}
 
-	addr, err := btcutil.NewAddressPubKey(wif.SerializePubKey(), &chaincfg.TestNet3Params)
+	comp, err := btcutil.NewAddressPubKey(wif.PrivKey.PubKey().SerializeCompressed(), network.params)
+	if err != nil {
+		return w, err
+	}
+
+	uncomp, err := btcutil.NewAddressPubKey(wif.PrivKey.PubKey().SerializeUncompressed(), network.params)
 	if err != nil {
 		return w, err
 	}
 
 	w.wif = wifStr
-	w.addr = addr.EncodeAddress()
+	w.currency = network.currency
+	w.addrComp = comp.EncodeAddress()
+	w.addrUncomp = uncomp.EncodeAddress()
 	w.priKey = wif.PrivKey.D.Bytes()
-	w.pubKey = addr.ScriptAddress()
 
-	remPubKey, err := remotePublicKey(w.pubKey[len(w.pubKey)-1:])
-	if err != nil {
-		return w, fmt.Errorf("remote pubk: %v", err)
+	// the WIF says which form the wallet shows, that one is the
+	// address everything is keyed by but both are watched
+	w.addr, w.pubKey = w.addrUncomp, uncomp.ScriptAddress()
+	if wif.CompressPubKey {
+		w.addr, w.pubKey = w.addrComp, comp.ScriptAddress()
 	}
 
-	w.sharedKey, err = sharedKey(remPubKey, w.priKey)
-	if err != nil {
-		return w, err
+	return w, nil
+}
+
+// addrs returns both P2PKH forms of the address, the form the WIF
+// is for is always first. A watch-only address only has the one form.
+func (w WIF) addrs() []string {
+	if w.isWatchOnly() {
+		return []string{w.addr}
 	}
+	if w.addr == w.addrComp {
+		return []string{w.addrComp, w.addrUncomp}
+	}
+	return []string{w.addrUncomp, w.addrComp}
+}
 
-	switch b[0] {
-	case 0x80:
-		w.currency = "BTC"
-	case 0xB0:
-		w.currency = "LTC"
-	case 0x9E:
-		w.currency = "XDG"
-	case 0xEF, 0xF1:
-		w.currency = "-T-"
+// defaultDataDir returns the directory used to store the keystore and any
+// other files the client keeps between restarts
+func defaultDataDir() string {
+	if u, err := user.Current(); err == nil && u.HomeDir != "" {
+		return filepath.Join(u.HomeDir, ".pubkemail")
 	}
+	return ".pubkemail"
+}
 
-	return w, nil
+// sleepCtx sleeps for the duration or until the context is done
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
 }
 
 // randPrefix returns a base58 encoded random bytes. Base58
@@ -196,6 +258,14 @@ func txtTruncate(text string, length int) string {
 	return text
 }
 
+// fmtWatermark formats the last delivered time of an address for display
+func fmtWatermark(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
 // addrDataMapToString takes a map of addresses and related data
 // and returns a sorted string of each address on a row with
 // the related data in a standard format
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcutil"
+	flag "github.com/spf13/pflag"
+)
+
+// the form field names of the Add WIF form, keygen posts the
+// WIF field to the register endpoint of a running client
+const (
+	formSubmit       = "sub"
+	formSubmitAddWIF = "sub-add-wif"
+	formWIFStr       = "wif-str"
+
+	webRegisterPath = "register" // under the web interface URL
+)
+
+// keygenNetworks maps the network names that keys can
+// be generated for to their WIF network byte
+var keygenNetworks = map[string]byte{
+	"btc":     0x80,
+	"ltc":     0xB0,
+	"doge":    0x9E,
+	"testnet": 0xEF,
+}
+
+// KeygenDisplay holds a generated key that can be displayed
+// once on the user facing key generation page
+type KeygenDisplay struct {
+	CurAbv string
+	WIF    string
+	Email  string
+}
+
+// keygenNetworkNames returns the sorted names of the networks keys can be generated for
+func keygenNetworkNames() []string {
+	var names []string
+	for name := range keygenNetworks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// generateWIF returns a new compressed WIF for the network
+func generateWIF(network string) (WIF, error) {
+	netByte, ok := keygenNetworks[strings.ToLower(network)]
+	if !ok {
+		return WIF{}, fmt.Errorf("unknown network %q, use one of: %s", network, strings.Join(keygenNetworkNames(), ", "))
+	}
+
+	priKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return WIF{}, fmt.Errorf("generate key: %v", err)
+	}
+
+	wif, err := btcutil.NewWIF(priKey, wifNetworks[netByte].params, true)
+	if err != nil {
+		return WIF{}, fmt.Errorf("generate wif: %v", err)
+	}
+
+	return decodeWIF(wif.String())
+}
+
+// keygen is the keygen subcommand, it prints new WIFs and their pubkemail
+// email addresses. With --register the WIFs are added to a running client
+// through its web interface so they never need to be copied by hand.
+func keygen(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	network := fs.StringP("network", "n", "btc", "the network to generate keys for: "+strings.Join(keygenNetworkNames(), ", "))
+	count := fs.IntP("count", "c", 1, "the number of keys to generate")
+	register := fs.StringP("register", "r", "", "the web interface URL of a running client to add the keys to")
+	fs.Parse(args)
+
+	for i := 0; i < *count; i++ {
+		wif, err := generateWIF(*network)
+		if err != nil {
+			fmt.Printf("keygen: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s %s %s@pubkemail.com\n", wif.currency, wif.wif, wif.addr)
+
+		if *register != "" {
+			if err := keygenRegister(*register, wif.wif); err != nil {
+				fmt.Printf("keygen: register %s: %v\n", wif.addr, err)
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// keygenRegister posts the WIF to the register endpoint of a running client.
+// Redirects aren't followed, the web form redirects back to the page even
+// when the WIF wasn't added so only a 2xx status from the endpoint is success.
+func keygenRegister(webURL, wifStr string) error {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	resp, err := client.PostForm(strings.TrimSuffix(webURL, "/")+"/"+webRegisterPath, url.Values{
+		formWIFStr: {wifStr},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP status: %v %s", http.StatusText(resp.StatusCode), strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
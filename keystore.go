@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// defaultKeystoreName is the file name of the keystore within the data directory
+const defaultKeystoreName = "keystore.json"
+
+// keystoreVersion is the version of the on disk keystore format
+const keystoreVersion = 1
+
+// the scrypt cost parameters used when deriving the keystore key
+const (
+	keystoreScryptN = 1 << 15
+	keystoreScryptR = 8
+	keystoreScryptP = 1
+)
+
+// keystoreFile is the JSON written to disk, everything
+// except the KDF parameters is inside of the sealed box
+type keystoreFile struct {
+	Version int `json:"version"`
+	KDF     struct {
+		Name string `json:"name"`
+		Salt []byte `json:"salt"`
+		N    int    `json:"n"`
+		R    int    `json:"r"`
+		P    int    `json:"p"`
+	} `json:"kdf"`
+	Nonce []byte `json:"nonce"`
+	Box   []byte `json:"box"`
+}
+
+// keystoreData is the decrypted content of the keystore
+type keystoreData struct {
+	Fwds   []keystoreFwd     `json:"fwds"`
+	Addrs  []keystoreAddr    `json:"addrs"`
+	Groups []keystoreGroup   `json:"groups,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"` // keyed by address
+}
+
+// keystoreFwd is a saved forwarding HTTP-API or SMTP json
+type keystoreFwd struct {
+	Name string `json:"name"`
+	JSON string `json:"json"`
+}
+
+// keystoreAddr is a saved WIF and the forwarder it's assigned to
+type keystoreAddr struct {
+	WIF   string `json:"wif"`
+	FwdTo string `json:"fwd-to"`
+}
+
+// keystoreGroup is a saved extended private key, next is how
+// many addresses were derived so the window is the same on restart
+type keystoreGroup struct {
+	XPrv  string `json:"xprv"`
+	Path  string `json:"path"`
+	Gap   int    `json:"gap"`
+	Next  uint32 `json:"next"`
+	FwdTo string `json:"fwd-to"`
+}
+
+// keystore is a passphrase protected file that holds the WIFs and
+// forwarders so they survive restarts. The key is derived with scrypt
+// and the data is sealed with NaCl secretbox.
+type keystore struct {
+	m    sync.Mutex
+	path string
+	file keystoreFile
+	key  *[32]byte
+}
+
+// openKeystore reads the keystore at path, a keystore that doesn't
+// exist yet is not an error, it will be created on the first save
+func openKeystore(path string) (*keystore, error) {
+	ks := &keystore{path: path}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("keystore read: %v", err)
+	}
+
+	if err = json.Unmarshal(b, &ks.file); err != nil {
+		return nil, fmt.Errorf("keystore unmarshal: %v", err)
+	}
+
+	if ks.file.Version != keystoreVersion {
+		return nil, fmt.Errorf("keystore version %d is not supported", ks.file.Version)
+	}
+
+	return ks, nil
+}
+
+// isNew returns true if there is no keystore on disk yet
+func (ks *keystore) isNew() bool {
+	return ks.file.Version == 0
+}
+
+// isLocked returns true if the passphrase has not been supplied
+func (ks *keystore) isLocked() bool {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+	return ks.key == nil
+}
+
+// unlock derives the key from the passphrase and returns the decrypted data
+func (ks *keystore) unlock(passphrase []byte) (data keystoreData, err error) {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	if ks.isNew() {
+		ks.file.Version = keystoreVersion
+		ks.file.KDF.Name = "scrypt"
+		ks.file.KDF.N, ks.file.KDF.R, ks.file.KDF.P = keystoreScryptN, keystoreScryptR, keystoreScryptP
+		ks.file.KDF.Salt = make([]byte, 32)
+		if _, err = io.ReadFull(rand.Reader, ks.file.KDF.Salt); err != nil {
+			return data, fmt.Errorf("keystore salt: %v", err)
+		}
+	}
+
+	key, err := scrypt.Key(passphrase, ks.file.KDF.Salt, ks.file.KDF.N, ks.file.KDF.R, ks.file.KDF.P, 32)
+	if err != nil {
+		return data, fmt.Errorf("keystore kdf: %v", err)
+	}
+
+	var k [32]byte
+	copy(k[:], key)
+
+	if len(ks.file.Box) > 0 {
+		var nonce [24]byte
+		copy(nonce[:], ks.file.Nonce)
+
+		b, ok := secretbox.Open(nil, ks.file.Box, &nonce, &k)
+		if !ok {
+			return data, fmt.Errorf("keystore: the passphrase is incorrect")
+		}
+
+		if err = json.Unmarshal(b, &data); err != nil {
+			return data, fmt.Errorf("keystore data unmarshal: %v", err)
+		}
+	}
+
+	ks.key = &k
+	return data, nil
+}
+
+// save seals the data and atomically replaces the keystore on disk
+func (ks *keystore) save(data keystoreData) error {
+	ks.m.Lock()
+	defer ks.m.Unlock()
+
+	if ks.key == nil {
+		return fmt.Errorf("keystore is locked")
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("keystore data marshal: %v", err)
+	}
+
+	var nonce [24]byte
+	if _, err = io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return fmt.Errorf("keystore nonce: %v", err)
+	}
+
+	file := ks.file
+	file.Nonce = nonce[:]
+	file.Box = secretbox.Seal(nil, b, &nonce, ks.key)
+
+	b, err = json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("keystore marshal: %v", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(ks.path), 0700); err != nil {
+		return fmt.Errorf("keystore mkdir: %v", err)
+	}
+
+	tmp := ks.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("keystore write: %v", err)
+	}
+
+	if err = os.Rename(tmp, ks.path); err != nil {
+		return fmt.Errorf("keystore rename: %v", err)
+	}
+
+	ks.file = file
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultLedgerName is the file name of the ledger within the data directory
+const defaultLedgerName = "ledger.jsonl"
+
+// the outcomes of a message that are recorded in the ledger
+const (
+	ledgerForwarded   = "forwarded"
+	ledgerFailed      = "failed"
+	ledgerPending     = "pending"     // found for a watch-only address, waiting to be decrypted
+	ledgerQuarantined = "quarantined" // failed verification, archived but never forwarded
+	ledgerDiscarded   = "discarded"   // removed from the outbox by hand, archived but never forwarded
+	ledgerDead        = "dead"        // failed too many times, it's not tried again
+	ledgerQueued      = "queued"      // retried from the outbox by hand, the outbox records how it ends
+)
+
+// ledgerMaxAttempts is how many times a message that fails is tried, the
+// wait between the attempts is the same as the outbox
+const ledgerMaxAttempts = outboxMaxAttempts
+
+// ledgerEntry is a line in the ledger, the last line for
+// a content hash is the current outcome of that message
+type ledgerEntry struct {
+	Addr   string    `json:"addr"`
+	Hash   string    `json:"hash"`
+	Sum    string    `json:"sum,omitempty"` // the SHA-256 of the content from the feed link
+	TS     time.Time `json:"ts"`
+	Status string    `json:"status"`
+	Err    string    `json:"err,omitempty"`
+	Tries  int       `json:"tries,omitempty"` // the failed attempts in a row
+	File   string    `json:"file,omitempty"`  // the unique name in the maildir of the address
+	At     time.Time `json:"at"`
+}
+
+// ledger is an append only record of every content hash that was found
+// for an address and what happened when it was forwarded. It keeps
+// messages from being forwarded twice or skipped across restarts.
+type ledger struct {
+	m       sync.Mutex
+	f       *os.File
+	entries map[string]ledgerEntry
+	marks   map[string]time.Time
+}
+
+// openLedger reads the ledger at path into memory and keeps the
+// file open so that new entries can be appended
+func openLedger(path string) (*ledger, error) {
+	l := &ledger{
+		entries: make(map[string]ledgerEntry),
+		marks:   make(map[string]time.Time),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("ledger mkdir: %v", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("ledger open: %v", err)
+	}
+
+	scanner := bufio.NewScanner(f)
+	for ln := 1; scanner.Scan(); ln++ {
+		var e ledgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Warnf("ledger line %d: %v", ln, err)
+			continue
+		}
+		l.add(e)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("ledger read: %v", err)
+	}
+
+	l.f = f
+	return l, nil
+}
+
+// add updates the in memory entries and the watermark of the address
+func (l *ledger) add(e ledgerEntry) {
+	l.entries[e.Hash] = e
+	if e.Status == ledgerForwarded && e.TS.After(l.marks[e.Addr]) {
+		l.marks[e.Addr] = e.TS
+	}
+}
+
+// get returns the latest entry for a content hash
+func (l *ledger) get(hash string) (ledgerEntry, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	e, ok := l.entries[hash]
+	return e, ok
+}
+
+// watermark returns the timestamp of the newest message that
+// was forwarded for the address
+func (l *ledger) watermark(addr string) time.Time {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.marks[addr]
+}
+
+// record appends the outcome of a message to the ledger and syncs it to
+// disk, the status is forwarded unless there is an error. A message that
+// fails too many times in a row is dead.
+func (l *ledger) record(e ledgerEntry, err error) error {
+	e.Status, e.At = ledgerForwarded, time.Now()
+	if err != nil {
+		e.Status, e.Err, e.Tries = ledgerFailed, err.Error(), 1
+		if prev, ok := l.get(e.Hash); ok && prev.Status == ledgerFailed {
+			e.Tries = prev.Tries + 1
+		}
+		if e.Tries >= ledgerMaxAttempts {
+			e.Status = ledgerDead
+		}
+	}
+	return l.write(e)
+}
+
+// retryAt returns when a failed message can be tried again
+func (e ledgerEntry) retryAt() time.Time {
+	return e.At.Add(outboxBackoff(e.Tries))
+}
+
+// dead appends a message that the outbox gave up on to the ledger, the
+// tries are the attempts the outbox made
+func (l *ledger) dead(e ledgerEntry, err error) error {
+	e.Status, e.Err, e.At = ledgerDead, err.Error(), time.Now()
+	return l.write(e)
+}
+
+// queued appends a message that is in the outbox again to the ledger,
+// it starts over with no tries
+func (l *ledger) queued(e ledgerEntry) error {
+	e.Status, e.Tries, e.At = ledgerQueued, 0, time.Now()
+	return l.write(e)
+}
+
+// pending appends a message that is waiting to be decrypted to the ledger
+func (l *ledger) pending(e ledgerEntry) error {
+	e.Status, e.At = ledgerPending, time.Now()
+	return l.write(e)
+}
+
+// quarantine appends a message that failed verification to the ledger
+func (l *ledger) quarantine(e ledgerEntry, err error) error {
+	e.Status, e.Err, e.At = ledgerQuarantined, err.Error(), time.Now()
+	return l.write(e)
+}
+
+// discard appends a message that was discarded from the outbox to the ledger
+func (l *ledger) discard(e ledgerEntry) error {
+	e.Status, e.At = ledgerDiscarded, time.Now()
+	return l.write(e)
+}
+
+// pendingEntries returns the messages that are still waiting to be
+// decrypted, oldest first
+func (l *ledger) pendingEntries() []ledgerEntry {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	var entries []ledgerEntry
+	for _, e := range l.entries {
+		if e.Status == ledgerPending {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].TS.Before(entries[j].TS) })
+	return entries
+}
+
+// waiting returns the number of messages pending for the address
+func (l *ledger) waiting(addr string) (n int) {
+	for _, e := range l.pendingEntries() {
+		if e.Addr == addr {
+			n++
+		}
+	}
+	return n
+}
+
+// quarantined returns the number of messages quarantined for the address
+func (l *ledger) quarantined(addr string) (n int) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	for _, e := range l.entries {
+		if e.Addr == addr && e.Status == ledgerQuarantined {
+			n++
+		}
+	}
+	return n
+}
+
+// write appends the entry to the ledger and syncs it to disk
+func (l *ledger) write(e ledgerEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("ledger marshal: %v", err)
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if _, err = l.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("ledger write: %v", err)
+	}
+	if err = l.f.Sync(); err != nil {
+		return fmt.Errorf("ledger sync: %v", err)
+	}
+
+	l.add(e)
+	return nil
+}
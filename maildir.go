@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMaildirName is the directory within the data directory that
+// holds a maildir for each address
+const defaultMaildirName = "maildir"
+
+// maildirQuarantine is the Maildir++ folder within the maildir of an
+// address that holds the messages that failed verification
+const maildirQuarantine = ".Quarantine"
+
+// maildirDeliveries is used to keep maildir file names unique
+// when more than one message is delivered in the same instant
+var maildirDeliveries uint64
+
+// maildirName returns a unique file name as described at
+// https://cr.yp.to/proto/maildir.html
+func maildirName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	host = strings.Replace(host, "/", `\057`, -1)
+	host = strings.Replace(host, ":", `\072`, -1)
+
+	now := time.Now()
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), atomic.AddUint64(&maildirDeliveries, 1), host)
+}
+
+// maildirDeliver writes the message into the new directory of the maildir at
+// path, it's written to tmp first so that mail readers never see a partial
+// file. The unique name of the delivered file is returned, a mail reader
+// moves it to cur so maildirRead looks for it by name.
+func maildirDeliver(path string, msg *Message) (string, error) {
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(path, dir), 0700); err != nil {
+			return "", fmt.Errorf("maildir mkdir: %v", err)
+		}
+	}
+
+	name := maildirName()
+	tmp := filepath.Join(path, "tmp", name)
+	if err := ioutil.WriteFile(tmp, msg.Bytes(), 0600); err != nil {
+		return "", fmt.Errorf("maildir write: %v", err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(path, "new", name)); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("maildir rename: %v", err)
+	}
+
+	return name, nil
+}
+
+// maildirFind returns the path of the message with the unique name in the
+// maildir at path, it's in new or it's been moved to cur with flags added
+// (i.e. name:2,S)
+func maildirFind(path, name string) (string, error) {
+	for _, dir := range []string{"new", "cur"} {
+		files, err := ioutil.ReadDir(filepath.Join(path, dir))
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("maildir read dir: %v", err)
+		}
+		for _, fi := range files {
+			if fi.Name() == name || strings.HasPrefix(fi.Name(), name+":") {
+				return filepath.Join(path, dir, fi.Name()), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("maildir: no message %s in %s", name, path)
+}
+
+// maildirRead reads a message with the unique name from the maildir at path
+func maildirRead(path, name string) (*Message, error) {
+	file, err := maildirFind(path, name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("maildir open: %v", err)
+	}
+	defer f.Close()
+
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		return nil, fmt.Errorf("maildir parse: %v", err)
+	}
+
+	b, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		return nil, fmt.Errorf("maildir read: %v", err)
+	}
+
+	return &Message{Header: msg.Header, Body: string(b)}, nil
+}
+
+// maildirPath returns the maildir of an address in the data directory
+func (c *common) maildirPath(addr string) string {
+	return filepath.Join(c.dataDir, defaultMaildirName, addr)
+}
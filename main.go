@@ -1,16 +1,67 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
 	"github.com/njones/logger"
 )
 
 var log logger.Logger
 
+// subcommands are run instead of the client when they are the first argument
+var subcommands = map[string]func(args []string){
+	"agent":          agentCommand,
+	"check-link":     checkLink,
+	"decrypt":        decrypt,
+	"export-pending": exportPending,
+	"keygen":         keygen,
+	"simulate":       simulate,
+	"watch-key":      watchKey,
+}
+
 // main kicks everything off... what can I say.
 func main() {
 	log = logger.New().Suppress(logger.LevelPrint)
 
-	c := newCommon(flags()...)
+	// like ssh-agent the agent can be run as its own binary
+	if filepath.Base(os.Args[0]) == "pubkemail-agent" {
+		agentCommand(os.Args[1:])
+		return
+	}
+
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := newCommon(append(flags(), func(c *common) { c.ctx, c.cancel = ctx, cancel })...)
+	go c.signals()
 	go c.webServer()
-	c.terminal()
+	if c.daemon.headless {
+		c.headless()
+	} else {
+		c.terminal()
+	}
+	c.shutdown()
+}
+
+// signals stops the client on an interrupt or terminate signal, a
+// second signal aborts anything that is still downloading or sending
+func (c *common) signals() {
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	<-sig
+	c.cancel()
+	<-sig
+	c.abort()
 }
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultOutboxName is the file name of the outbox within the data directory
+const defaultOutboxName = "outbox.json"
+
+// the retry schedule of the outbox, the wait doubles after
+// each attempt until it reaches the max
+const (
+	outboxMaxAttempts = 8
+	outboxBaseWait    = 30 * time.Second
+	outboxMaxWait     = 2 * time.Hour
+	outboxTick        = 15 * time.Second
+)
+
+// outboxItem is a decrypted message that is waiting to be forwarded
+type outboxItem struct {
+	Addr     string    `json:"addr"`
+	Hash     string    `json:"hash"`
+	Sum      string    `json:"sum,omitempty"`
+	TS       time.Time `json:"ts"`
+	File     string    `json:"file,omitempty"` // the unique name of the archived message in the maildir
+	Raw      []byte    `json:"raw,omitempty"`  // the message when it couldn't be archived
+	Attempts int       `json:"attempts"`
+	Next     time.Time `json:"next"`
+	Err      string    `json:"err,omitempty"`
+	Dead     bool      `json:"dead,omitempty"` // no more retries, waiting to be retried or discarded by hand
+	Added    time.Time `json:"added"`
+}
+
+// OutboxDisplay holds data that can be displayed on the
+// user facing outbox page about a queued message
+type OutboxDisplay struct {
+	Hash     string
+	Addr     string
+	FwdTo    string
+	Subject  string
+	Received string
+	Attempts int
+	Next     string
+	Err      string
+	Dead     bool
+}
+
+// outbox is a persistent queue between decryption and forwarding, so a
+// message that can't be forwarded right away is retried with a backoff
+// instead of being lost. Messages that fail permanently, or too many
+// times, are kept as dead letters until they are retried or discarded.
+type outbox struct {
+	m        sync.Mutex
+	path     string
+	items    map[string]*outboxItem
+	inflight map[string]bool
+	kick     chan struct{}
+}
+
+// openOutbox reads the outbox at path, a missing file is an empty outbox
+func openOutbox(path string) (*outbox, error) {
+	o := &outbox{
+		path:     path,
+		items:    make(map[string]*outboxItem),
+		inflight: make(map[string]bool),
+		kick:     make(chan struct{}, 1),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("outbox read: %v", err)
+	}
+
+	var items []*outboxItem
+	if err = json.Unmarshal(b, &items); err != nil {
+		return nil, fmt.Errorf("outbox unmarshal: %v", err)
+	}
+	for _, item := range items {
+		o.items[item.Hash] = item
+	}
+	return o, nil
+}
+
+// save writes the outbox to a temp file and renames it over the old one,
+// the lock must be held
+func (o *outbox) save() error {
+	items := make([]*outboxItem, 0, len(o.items))
+	for _, item := range o.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Added.Before(items[j].Added) })
+
+	b, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return fmt.Errorf("outbox marshal: %v", err)
+	}
+
+	tmp := o.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("outbox write: %v", err)
+	}
+	if err = os.Rename(tmp, o.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("outbox rename: %v", err)
+	}
+	return nil
+}
+
+// has returns true if the message is in the outbox
+func (o *outbox) has(hash string) bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	_, ok := o.items[hash]
+	return ok
+}
+
+// add puts the message in the outbox, it's claimed by
+// the caller so that it can be sent right away
+func (o *outbox) add(item outboxItem) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	item.Added, item.Next = time.Now(), time.Now()
+	o.items[item.Hash] = &item
+	o.inflight[item.Hash] = true
+	return o.save()
+}
+
+// due claims and returns the messages that are ready to be sent
+func (o *outbox) due() (items []outboxItem) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	now := time.Now()
+	for hash, item := range o.items {
+		if item.Dead || o.inflight[hash] || item.Next.After(now) {
+			continue
+		}
+		o.inflight[hash] = true
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Added.Before(items[j].Added) })
+	return items
+}
+
+// done releases a claimed message, it's removed when it was sent
+func (o *outbox) done(item outboxItem, sent bool) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	delete(o.inflight, item.Hash)
+	if sent {
+		delete(o.items, item.Hash)
+	} else {
+		o.items[item.Hash] = &item
+	}
+	return o.save()
+}
+
+// retry moves a message back to the front of the queue with a fresh set of
+// attempts, a message that is being sent can't be retried
+func (o *outbox) retry(hash string) (outboxItem, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	item, ok := o.items[hash]
+	if !ok || o.inflight[hash] {
+		return outboxItem{}, fmt.Errorf("outbox: no message %s that can be retried", hash)
+	}
+	item.Dead, item.Attempts, item.Next = false, 0, time.Now()
+	if err := o.save(); err != nil {
+		return outboxItem{}, err
+	}
+
+	select {
+	case o.kick <- struct{}{}:
+	default:
+	}
+	return *item, nil
+}
+
+// discard removes a message from the outbox without sending it
+func (o *outbox) discard(hash string) (outboxItem, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	item, ok := o.items[hash]
+	if !ok || o.inflight[hash] {
+		return outboxItem{}, fmt.Errorf("outbox: no message %s that can be discarded", hash)
+	}
+	delete(o.items, hash)
+	return *item, o.save()
+}
+
+// list returns every message in the outbox, oldest first
+func (o *outbox) list() (items []outboxItem) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	for _, item := range o.items {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Added.Before(items[j].Added) })
+	return items
+}
+
+// message returns the queued message from the maildir at path or the outbox
+func (item outboxItem) message(path string) (*Message, error) {
+	if item.File != "" {
+		return maildirRead(path, item.File)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(item.Raw))
+	if err != nil {
+		return nil, fmt.Errorf("outbox parse: %v", err)
+	}
+	b, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		return nil, fmt.Errorf("outbox read: %v", err)
+	}
+	return &Message{Header: msg.Header, Body: string(b)}, nil
+}
+
+// outboxBackoff returns how long to wait after the number of attempts
+func outboxBackoff(attempts int) time.Duration {
+	wait := outboxBaseWait
+	for i := 1; i < attempts && wait < outboxMaxWait; i++ {
+		wait *= 2
+	}
+	if wait > outboxMaxWait {
+		wait = outboxMaxWait
+	}
+	return wait
+}
+
+// fwdTemporary returns true if a forwarding error is worth retrying, a 4xx
+// SMTP reply, a 429 or 5xx HTTP status and network errors are temporary.
+// Anything else (a 5xx SMTP reply, a bad template, etc.) won't get better.
+func fwdTemporary(err error) bool {
+	switch e := err.(type) {
+	case *textproto.Error:
+		return e.Code >= 400 && e.Code < 500
+	case fwdHTTPStatusError:
+		return e.code == 429 || e.code >= 500
+	case net.Error:
+		return true
+	}
+	return err == io.EOF || err == context.Canceled || err == context.DeadlineExceeded
+}
+
+// queueMessage puts a decrypted message in the outbox and tries to send it
+func (c *common) queueMessage(addr, contentEmailHash, sum string, tsThen time.Time, file string, message *Message) {
+	item := outboxItem{Addr: addr, Hash: contentEmailHash, Sum: sum, TS: tsThen, File: file}
+	if file == "" {
+		item.Raw = message.Bytes() // the archive failed so the outbox holds it
+	}
+
+	if err := c.outbox.add(item); err != nil {
+		log.Warnf("outbox add: %v", err)
+	}
+	c.sendQueued(item, message)
+}
+
+// sendQueued makes an attempt at forwarding a claimed message, it's removed
+// from the outbox when it's sent, otherwise it's retried later or becomes
+// a dead letter. The message is read from the outbox item if it's nil. Only
+// the outcome (forwarded or dead) is recorded in the ledger, the attempts
+// in between are kept by the outbox.
+func (c *common) sendQueued(item outboxItem, message *Message) {
+	var err error
+	if message == nil {
+		message, err = item.message(c.maildirPath(item.Addr))
+	}
+
+	var fwdTo string
+	temporary := true
+	if err == nil {
+		display, _ := c.addrDisplayOf(item.Addr)
+		fwdTo = display.FwdTo
+		if fn, ok := c.fwdOf(fwdTo); !ok {
+			// there may be a forwarder for it later
+			err = fmt.Errorf("no forwarder %q", fwdTo)
+		} else {
+			from := message.Header.Get("From")
+			subj := message.Header.Get("Subject")
+			rcpt := fwdRcpt{addr: item.Addr, label: display.Label}
+			err = fn.fwdEmail(c.work, rcpt, from, subj, message.Body, message.Header, false)
+			temporary = err == nil || fwdTemporary(err)
+		}
+	}
+
+	// an attempt that was cut short by the client stopping doesn't count
+	if err != nil && (err == context.Canceled || c.work.Err() != nil) {
+		oerr := c.outbox.done(item, false)
+		log.OnErr(oerr).Warnf("outbox: %v", oerr)
+		return
+	}
+
+	item.Attempts++
+	switch {
+	case err == nil:
+		item.Err = ""
+	case !temporary || item.Attempts >= outboxMaxAttempts:
+		item.Err, item.Dead = err.Error(), true
+		log.Warnf("forward email %s to %q failed for good after %d attempt(s): %v", item.Hash, fwdTo, item.Attempts, err)
+	default:
+		item.Err, item.Next = err.Error(), time.Now().Add(outboxBackoff(item.Attempts))
+		log.Warnf("forward email %s to %q, retrying at %s: %v", item.Hash, fwdTo, item.Next.Format(time.RFC3339), err)
+	}
+
+	oerr := c.outbox.done(item, err == nil)
+	log.OnErr(oerr).Warnf("outbox: %v", oerr)
+
+	entry := ledgerEntry{Addr: item.Addr, Hash: item.Hash, Sum: item.Sum, TS: item.TS, File: item.File}
+	switch {
+	case err == nil:
+		lerr := c.ledger.record(entry, nil)
+		log.OnErr(lerr).Warnf("ledger record: %v", lerr)
+	case item.Dead:
+		entry.Tries = item.Attempts
+		lerr := c.ledger.dead(entry, err)
+		log.OnErr(lerr).Warnf("ledger record: %v", lerr)
+	}
+
+	if err == nil {
+		lastDelivered := fmtWatermark(c.ledger.watermark(item.Addr))
+		c.updateAddrDisplay(item.Addr, func(display *AddrDisplay) { display.LastDelivered = lastDelivered })
+	}
+}
+
+// runOutbox sends the messages in the outbox as they come due, until
+// the client is stopping
+func (c *common) runOutbox() {
+	ticker := time.NewTicker(outboxTick)
+	defer ticker.Stop()
+
+	for {
+		for _, item := range c.outbox.due() {
+			if c.ctx.Err() != nil {
+				c.outbox.done(item, false)
+				continue
+			}
+			c.sendQueued(item, nil)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.outbox.kick:
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// outboxDisplay returns the queued messages for the outbox page
+func (c *common) outboxDisplay() (display []OutboxDisplay) {
+	for _, item := range c.outbox.list() {
+		addrDisplay, _ := c.addrDisplayOf(item.Addr)
+		d := OutboxDisplay{
+			Hash:     item.Hash,
+			Addr:     item.Addr,
+			FwdTo:    addrDisplay.FwdTo,
+			Received: item.TS.Format(time.RFC822),
+			Attempts: item.Attempts,
+			Err:      item.Err,
+			Dead:     item.Dead,
+		}
+		if !item.Dead {
+			d.Next = item.Next.Format(time.RFC822)
+		}
+		if message, err := item.message(c.maildirPath(item.Addr)); err == nil {
+			d.Subject = fwdDecodeHeader(message.Header.Get("Subject"))
+		}
+		display = append(display, d)
+	}
+	return display
+}
+
+// outboxPath returns the path of the outbox in the data directory
+func outboxPath(dataDir string) string {
+	return filepath.Join(dataDir, defaultOutboxName)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// defaultServerKeysName is the directory within the data directory
+// that holds the pinned pubkemail shared public keys
+const defaultServerKeysName = "keys"
+
+// releaseKey is the hex ed25519 public key that signs key manifests, release
+// builds set it with -ldflags "-X main.releaseKey=<hex>". Without it key
+// manifests can't be verified and are refused.
+var releaseKey = ""
+
+// keyManifest is a list of pubkemail shared public keys signed with the
+// release key, the signature is over the exact bytes of keys
+type keyManifest struct {
+	Keys      json.RawMessage `json:"keys"` // the hex address public key byte to the hex shared public key
+	Signature string          `json:"signature"`
+}
+
+// the sources a shared public key can come from
+const (
+	keySourceManifest = "manifest"
+	keySourceFile     = "file"
+	keySourcePinned   = "pinned"
+	keySourceFetched  = "fetched"
+)
+
+// serverKeys are the pubkemail shared public keys that have been pinned
+// to disk, loaded from a signed manifest or supplied as local files. Keys
+// are trusted on first use, after that a key that changes is refused with
+// a loud warning.
+var serverKeys = struct {
+	m        sync.Mutex
+	dir      string
+	manifest map[string][]byte
+	files    map[string]serverKeyFile // "" is the key for any address
+}{}
+
+// serverKeyFile is a shared public key supplied in a local file
+type serverKeyFile struct {
+	key  []byte
+	path string
+}
+
+// openServerKeys sets the directory the keys are pinned in and loads
+// the key manifest and local key files, if there are any
+func openServerKeys(dir, manifestPath, filesPath string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("server keys mkdir: %v", err)
+	}
+
+	var err error
+	var manifest map[string][]byte
+	if manifestPath != "" {
+		if manifest, err = loadKeyManifest(manifestPath); err != nil {
+			return err
+		}
+	}
+
+	var files map[string]serverKeyFile
+	if filesPath != "" {
+		if files, err = loadServerKeyFiles(filesPath); err != nil {
+			return err
+		}
+	}
+
+	serverKeys.m.Lock()
+	serverKeys.dir, serverKeys.manifest, serverKeys.files = dir, manifest, files
+	serverKeys.m.Unlock()
+	return nil
+}
+
+// loadServerKeyFiles reads the shared public keys from a file, or every .pem
+// file in a directory, so they never need to be fetched. Each PEM block is
+// for the address byte in its Key-Id header, or in the file name (1c.pem)
+// when there's no header. A block with neither is used for any address.
+func loadServerKeyFiles(path string) (map[string]serverKeyFile, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("server keys: %v", err)
+	}
+
+	paths := []string{path}
+	if fi.IsDir() {
+		if paths, err = filepath.Glob(filepath.Join(path, "*.pem")); err != nil {
+			return nil, fmt.Errorf("server keys: %v", err)
+		}
+	}
+
+	files := make(map[string]serverKeyFile)
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("server keys read: %v", err)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(path), ".pem")
+		if _, err := hex.DecodeString(name); err != nil {
+			name = ""
+		}
+
+		for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+			key, err := unmarshalPublicKey(block)
+			if err != nil {
+				return nil, fmt.Errorf("server keys %s: %v", path, err)
+			}
+
+			id, ok := block.Headers["Key-Id"]
+			if !ok {
+				id = name
+			}
+			files[strings.ToLower(id)] = serverKeyFile{key: key, path: path}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("server keys: no PEM blocks found in %s", path)
+	}
+	return files, nil
+}
+
+// keyFingerprint returns a short fingerprint of a key that can be displayed
+func keyFingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:6])
+}
+
+// loadKeyManifest reads the manifest at path and verifies it with the release key
+func loadKeyManifest(path string) (map[string][]byte, error) {
+	if releaseKey == "" {
+		return nil, fmt.Errorf("key manifest: this build has no release key to verify it with")
+	}
+
+	pub, err := hex.DecodeString(releaseKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("key manifest: the release key is invalid")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("key manifest read: %v", err)
+	}
+
+	var m keyManifest
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("key manifest unmarshal: %v", err)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("key manifest signature: %v", err)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pub), m.Keys, sig) {
+		return nil, fmt.Errorf("key manifest signature is invalid")
+	}
+
+	var keys map[string]string
+	if err = json.Unmarshal(m.Keys, &keys); err != nil {
+		return nil, fmt.Errorf("key manifest keys: %v", err)
+	}
+
+	// the ids are looked up in lower case hex, like the fetched keys
+	manifest := make(map[string][]byte)
+	for x, key := range keys {
+		if manifest[strings.ToLower(x)], err = hex.DecodeString(key); err != nil {
+			return nil, fmt.Errorf("key manifest key %s: %v", x, err)
+		}
+	}
+	return manifest, nil
+}
+
+// remotePublicKey returns the pubkemail public key that coorosponds to the
+// public key of an address that has been submited. This is what the shared
+// key is based on. A key from the signed manifest always wins, then a key from
+// the local files, otherwise the key is fetched and checked against the key that
+// was pinned the first time. The source the key came from is also returned.
+func remotePublicKey(x []byte) ([]byte, string, error) {
+	id := hex.EncodeToString(x)
+
+	serverKeys.m.Lock()
+	dir := serverKeys.dir
+	key, ok := serverKeys.manifest[id]
+	file, fileOK := serverKeys.files[id]
+	if !fileOK {
+		file, fileOK = serverKeys.files[""]
+	}
+	serverKeys.m.Unlock()
+
+	switch {
+	case ok:
+		return key, keySourceManifest, nil
+	case fileOK:
+		return file.key, keySourceFile + " " + file.path, nil
+	}
+
+	var pinned []byte
+	pinFile := filepath.Join(dir, id+".pem")
+	if dir != "" {
+		if b, err := ioutil.ReadFile(pinFile); err == nil {
+			if pinned, err = parsePublicKeyPEM(b); err != nil {
+				return nil, "", fmt.Errorf("pinned public key %s: %v", pinFile, err)
+			}
+		}
+	}
+
+	body, err := fetchPublicKeyPEM(x)
+	if err != nil {
+		if pinned != nil {
+			log.Warnf("using the pinned public key, %v", err)
+			return pinned, keySourcePinned, nil
+		}
+		return nil, "", err
+	}
+
+	key, err = parsePublicKeyPEM(body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch {
+	case pinned != nil && !bytes.Equal(pinned, key):
+		log.Warnf("!!! WARNING: THE PUBKEMAIL SHARED PUBLIC KEY FOR %s HAS CHANGED !!! "+
+			"The pinned key is still used, someone may be trying to read your email. "+
+			"If pubkemail announced a new key delete %s to trust it.", id, pinFile)
+		return pinned, keySourcePinned, nil
+	case pinned != nil:
+		return pinned, keySourcePinned, nil
+	case dir != "":
+		err = pinPublicKey(pinFile, body)
+		log.OnErr(err).Warnf("pin public key: %v", err)
+	}
+
+	return key, keySourceFetched, nil
+}
+
+// pinPublicKey writes the PEM of a key to the pin file, it's written to a
+// temp file that is renamed so a partly written pin is never read back
+func pinPublicKey(pinFile string, body []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(pinFile), filepath.Base(pinFile)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, pinFile)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// fetchPublicKeyPEM downloads the PEM encoded shared public key for x
+func fetchPublicKeyPEM(x []byte) ([]byte, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/public/key/%x", apiURL.shared, x))
+	if err != nil {
+		return nil, fmt.Errorf("http get public key: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http get status: %v", http.StatusText(resp.StatusCode))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("http get read body: %v", err)
+	}
+
+	if len(body) == 0 {
+		return nil, fmt.Errorf("http get public key empty pem block")
+	}
+	return body, nil
+}
+
+// parsePublicKeyPEM returns the public key bytes from the PEM/ASN.1 encoded key
+func parsePublicKeyPEM(body []byte) ([]byte, error) {
+	block, rest := pem.Decode(body)
+	if block == nil {
+		return nil, fmt.Errorf("public key pem block: not found")
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("public key pem block: extra data")
+	}
+	return unmarshalPublicKey(block)
+}
+
+// unmarshalPublicKey returns the public key bytes from the ASN.1 in a PEM block
+func unmarshalPublicKey(block *pem.Block) ([]byte, error) {
+	var ecPubKey = ecPublicKey{}
+	rum, err := asn1.Unmarshal(block.Bytes, &ecPubKey)
+	if err != nil {
+		return nil, fmt.Errorf("public key unmarshal: %v", err)
+	}
+
+	if len(rum) != 0 {
+		return nil, fmt.Errorf("public key unmarshal asn1: extra data")
+	}
+
+	return ecPubKey.PublicKey.Bytes, nil
+}
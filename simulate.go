@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/njones/bitcoin-crypto/bitelliptic"
+	flag "github.com/spf13/pflag"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// the OIDs used in the simulated shared public key
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidNamedCurveS256 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+)
+
+// simItem is an item in the simulated RSS feed
+type simItem struct {
+	Title   string `xml:"title"`
+	Link    string `xml:"link"`
+	GUID    string `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+}
+
+// simulator is a local stand in for the rss, content and shared pubkemail
+// services. It holds its own server side keypair and sends encrypted
+// emails to the WIFs it was started with.
+type simulator struct {
+	m       sync.Mutex
+	baseURL string
+	key     *ecdsa.PrivateKey
+	priKey  []byte
+	pubKey  []byte
+	wifs    []WIF
+	emls    [][]byte
+	sent    int
+	items   []simItem // newest first
+	content map[string][]byte
+}
+
+// newSimulator returns a simulator with a new server side keypair
+func newSimulator(baseURL string, wifs []WIF, emls [][]byte) (*simulator, error) {
+	key, err := ecdsa.GenerateKey(bitelliptic.S256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate server key: %v", err)
+	}
+
+	return &simulator{
+		baseURL: baseURL,
+		key:     key,
+		priKey:  key.D.Bytes(),
+		pubKey:  elliptic.Marshal(bitelliptic.S256(), key.X, key.Y),
+		wifs:    wifs,
+		emls:    emls,
+		content: make(map[string][]byte),
+	}, nil
+}
+
+// simulate is the simulate subcommand, it serves a fake feed, encrypted
+// content and shared public keys so the client can be run end to end
+// without a network connection
+func simulate(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	port := fs.IntP("port", "p", 18820, "the port to serve the simulated pubkemail services on")
+	wifStrs := fs.StringSliceP("wif", "w", nil, "a WIF to send simulated email to, can be repeated")
+	count := fs.IntP("count", "n", 3, "the number of emails each WIF starts with")
+	every := fs.DurationP("every", "e", time.Minute, "how often a new email is sent to each WIF, 0 to never send more")
+	emlDir := fs.StringP("messages", "m", "", "a directory of .eml files to send instead of generated emails")
+	fs.Parse(args)
+
+	if len(*wifStrs) == 0 {
+		fmt.Println("simulate: at least one --wif is needed")
+		os.Exit(1)
+	}
+
+	var wifs []WIF
+	for _, wifStr := range *wifStrs {
+		wif, err := decodeWIF(wifStr)
+		if err != nil {
+			fmt.Printf("simulate: decode wif: %v\n", err)
+			os.Exit(1)
+		}
+		wifs = append(wifs, wif)
+	}
+
+	var emls [][]byte
+	if *emlDir != "" {
+		files, err := filepath.Glob(filepath.Join(*emlDir, "*.eml"))
+		if err != nil {
+			fmt.Printf("simulate: messages: %v\n", err)
+			os.Exit(1)
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				fmt.Printf("simulate: messages: %v\n", err)
+				os.Exit(1)
+			}
+			emls = append(emls, b)
+		}
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		fmt.Printf("simulate: listen: %v\n", err)
+		os.Exit(1)
+	}
+
+	baseURL := fmt.Sprintf("http://localhost:%d", listener.Addr().(*net.TCPAddr).Port)
+	sim, err := newSimulator(baseURL, wifs, emls)
+	if err != nil {
+		fmt.Printf("simulate: %v\n", err)
+		os.Exit(1)
+	}
+
+	for i := 0; i < *count; i++ {
+		if err := sim.sendAll(); err != nil {
+			fmt.Printf("simulate: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *every > 0 {
+		go func() {
+			for range time.Tick(*every) {
+				err := sim.sendAll()
+				log.OnErr(err).Warnf("simulate: %v", err)
+			}
+		}()
+	}
+
+	fmt.Printf("pubkemail simulator listening on %s, run the client with:\n", baseURL)
+	fmt.Printf("  --api-rss %[1]s --api-content %[1]s --api-shared %[1]s\n", baseURL)
+	for _, wif := range wifs {
+		fmt.Printf("  sending to %s@pubkemail.com\n", wif.addr)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/feed", sim.feedHandler)
+	r.Get("/v1/{hash}", sim.contentHandler)
+	r.Get("/public/key/{x}", sim.publicKeyHandler)
+	http.Serve(listener, r)
+}
+
+// sendAll sends a new email to every WIF
+func (sim *simulator) sendAll() error {
+	for _, wif := range sim.wifs {
+		if err := sim.send(wif, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send encrypts and signs an email to the WIF and adds it to the feed the
+// same way pubkemail does. The hash in the link is the SHA-256 of the
+// content so that it can be verified after it's downloaded.
+func (sim *simulator) send(wif WIF, now time.Time) error {
+	sim.m.Lock()
+	defer sim.m.Unlock()
+
+	sim.sent++
+	eml := sim.eml(wif, now)
+
+	ts := now.UnixNano()
+	entity, err := newWIFEntity(wif.priKey, time.Unix(0, ts))
+	if err != nil {
+		return err
+	}
+
+	// signed with the server key created at the timestamp, see newServerEntity
+	data := encData{timestamp: time.Unix(0, ts), name: "pubkemail", email: "simulator@pubkemail.com"}
+	signer, err := newEntity(data, sim.key, newECDHKey(sim.priKey))
+	if err != nil {
+		return fmt.Errorf("signing entity: %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	b64 := base64.NewEncoder(base64.StdEncoding, buf)
+	w, err := openpgp.Encrypt(b64, openpgp.EntityList{entity}, signer, nil, &packet.Config{
+		DefaultHash: crypto.RIPEMD160,
+	})
+	if err != nil {
+		return fmt.Errorf("encrypt email: %v", err)
+	}
+	if _, err = w.Write(eml); err != nil {
+		return fmt.Errorf("encrypt email: %v", err)
+	}
+	w.Close()
+	b64.Close()
+
+	shrKey, err := sharedKey(wif.pubKey, sim.priKey)
+	if err != nil {
+		return fmt.Errorf("shared key: %v", err)
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	conHash, tsStr := hex.EncodeToString(sum[:]), strconv.FormatInt(ts, 10)
+
+	mmac := hmac.New(sha256.New, shrKey)
+	fmt.Fprintf(mmac, "com.pubkemail.meta.v1:%s/%s:%s", wif.addr, conHash, tsStr)
+	check := hex.EncodeToString(mmac.Sum(nil))
+
+	macc := hmac.New(sha256.New, shrKey)
+	fmt.Fprintf(macc, "com.pubkemail.content.v1:%s/%s:%s", wif.addr, conHash, tsStr)
+	sim.content[hex.EncodeToString(macc.Sum(nil))] = buf.Bytes()
+
+	sim.items = append([]simItem{{
+		Title:   "email " + conHash[:8],
+		Link:    fmt.Sprintf("%s/meta?check=%s&hash=%s&ts=%s", sim.baseURL, check, conHash, tsStr),
+		GUID:    check,
+		PubDate: now.Format(time.RFC1123Z),
+	}}, sim.items...)
+
+	return nil
+}
+
+// eml returns the next email to send, either from the messages
+// directory or a generated one
+func (sim *simulator) eml(wif WIF, now time.Time) []byte {
+	if len(sim.emls) > 0 {
+		return sim.emls[(sim.sent-1)%len(sim.emls)]
+	}
+
+	return []byte(fmt.Sprintf("From: Simulator <simulator@pubkemail.com>\r\n"+
+		"To: %[1]s@pubkemail.com\r\n"+
+		"Subject: Simulated email #%[2]d\r\n"+
+		"Date: %[3]s\r\n"+
+		"Message-ID: <simulated.%[2]d.%[4]d@pubkemail.com>\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"\r\n"+
+		"This is simulated email #%[2]d sent to %[1]s@pubkemail.com at %[3]s.\r\n",
+		wif.addr, sim.sent, now.Format(time.RFC1123Z), now.UnixNano()))
+}
+
+// feedHandler serves a page of the RSS feed, the GENESIS-ITEM is
+// the last item of the feed just like the real one
+func (sim *simulator) feedHandler(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 250
+	}
+
+	sim.m.Lock()
+	items := append(append([]simItem{}, sim.items...), simItem{Title: "genesis", GUID: "GENESIS-ITEM"})
+	sim.m.Unlock()
+
+	start, end := (page-1)*limit, page*limit
+	if start > len(items) {
+		start = len(items)
+	}
+	if end > len(items) {
+		end = len(items)
+	}
+
+	feed := struct {
+		XMLName xml.Name `xml:"rss"`
+		Version string   `xml:"version,attr"`
+		Channel struct {
+			Title       string    `xml:"title"`
+			Link        string    `xml:"link"`
+			Description string    `xml:"description"`
+			Items       []simItem `xml:"item"`
+		} `xml:"channel"`
+	}{Version: "2.0"}
+	feed.Channel.Title = "pubkemail simulator"
+	feed.Channel.Link = sim.baseURL
+	feed.Channel.Description = "simulated pubkemail feed"
+	feed.Channel.Items = items[start:end]
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		log.Warnf("simulate feed: %v", err)
+	}
+}
+
+// contentHandler serves the base64 encoded encrypted content
+func (sim *simulator) contentHandler(w http.ResponseWriter, r *http.Request) {
+	sim.m.Lock()
+	b, ok := sim.content[chi.URLParam(r, "hash")]
+	sim.m.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(b)
+}
+
+// publicKeyHandler serves the server side public key in the same
+// PEM/ASN.1 format as pubkemail, the same key is used for every address
+func (sim *simulator) publicKeyHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := asn1.Marshal(ecPublicKey{
+		ID:            oidPublicKeyECDSA,
+		NamedCurveOID: oidNamedCurveS256,
+		PublicKey:     asn1.BitString{Bytes: sim.pubKey, BitLength: len(sim.pubKey) * 8},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: b})
+}
@@ -21592,19 +21592,20 @@ mSLLA6oaXY/oAGD0GgzPLnQgPy43Vmt+2C4Tp+/77wc9rTyX9Tm7Atu33NgvVv0PwyuoyaypN0FU5XUg
 
 	"/assets/static/tmpls/sidebar.html": {
 		local:   "site/adminator/build/assets/static/tmpls/sidebar.html",
-		size:    2367,
-		modtime: 1531691819,
+		size:    3211,
+		modtime: 1792198350,
 		compressed: `
-H4sIAAAAAAAC/8RWTY/aMBC976+Ymr2aUFVcqsChaqv2UAlttz/ASYZktI6dtR2givjvVT5IExKiiK7U
-C2DP8/PMezMWRQER7kkhMEsRBsIwOJ8figIeMxEjfNwAqQhPsHzW2VcpYgusDFQoP6IDhFJYu2lPbx8A
-RvY5KYV1FMB/xzksFgt4/FlH4RuKCE21x3kDGiGROtYNRx+QIRoLgngI+9ORq2OLGuKg/OCxQYw6KABf
-DK4j9QIu4opBYnC/YUUByyd8zdG6X0/fd8IlcD57lUDLxKWyxzc/w3H0ANCH9LTogSiNwZpww4S16Kxn
-nXAUepSKGK1XnltmKmYgpNuwsVu8iA6D7EY3Z0rboJN1m3zC30P6ia+gTIc7PDm23eXBC6aCpO8l6xn3
-D7Z8T3Rs70cn1e0GUx2QRO50HEuESyvUy2t/RdMX7HK4apZB6tSGiQtj9JGHZEKJXOLesa3v0XVhol9W
-v5LusrNoft6YsB+o8v585fK639MSY0OjpRSBRMi05X+F8mVbhxIHTg5TSJ/5hxWI0NGhK874KN01Rb7N
-hLqwUagVT7SMBtNRFEB7wNfm2WJduvKpGvcj5IHMka9XK3DEE53iiBtFASgtTrIYfVQzaFTUZ/G9srqb
-5TpyZc99FjYJtDDRNbzTJ74nacKof/Smmsq7vRlxp0N4rettf6pDI8retGjCpGmu+3z6Ur9d/8mjzFBI
-Kn5Ll3qU831KtcLfb+TTNNd9Pu3qsmY55Xu5rP7S1I9r89Xe/ScAAP//9FSimj8JAAA=
+H4sIAAAAAAAC/8WXz46bMBDG7/sUU7JXh1TVXiqyh6qtWlVVo+32AQxMwIqxWdskqaJ995o/oRAIRRQp
+lyR4Pn/2zM9jkdMJQtwygeBoFqJPlQOvr3enE9ynNEJ4vwYmQjzC8lmmnzmNNDh5oFB5IdtDwKnW63r2
+4x1AzzhhQmAZtfE3hMBisYD7n2UUviANURVjhFSiHhMuI1l5tAUpotJAGQlgezwQcahVXR3kHyRSiGFD
+ZXW0sxwTOzAhEQ7ECrdrx5Zl+YQvGWrz6+nrhprYlsEtCrSMTcJbfuN32K/uCNqSVi1aIpZEoFWwdqwO
+jXa1oYYFLkssN+3m85apiByg3KydvlVcu0xnd72DI0tbqeOHevMxeQvJB7KCfDvE4NE4j5vM32FCGffc
++GHE+p0hz6UN7O3oYHWbwUT6jCMxMoo4wvkolI+XfGl1Lpzz5OKwdLbO6jAjVCl5IAFTgV2E49bm7bns
+MjHaTqudSfOx8VD9vNJh31Fk7f7K+OV5T3KNDpTknPo2+VRq8rdQHq/zEHRPmMEEkmfybgU0MGzfLE5/
+K03qIk+nVJzdWCAFiSUPO91hPdkW8KW6tpymXX5V9fMIiM8zJA+rFVg0sUywh4a1Rq5x0MUyFSNsRNh2
+8dw8u6vpGmbyM/eR6tiXVIWX8sY58VzOBkD9J5uiKyez6aHTMLys63U+xaSeyl5FNABp2Gsap0/l3XUj
+Rjv8HaGYE1LTcTwlO2smRkNO0wh9KzK6GSKZGV8e50TUdByPSCGXNJyJ0j/MpoH6UeR1M1CpYgET0Zyk
+WpbjUSVSzNZPw17TQG3KtEaR8tyMF38PyheV6qte+w9fD/AuiwwAAA==
 `,
 	},
 
@@ -27495,41 +27496,108 @@ Yc3SlFxPim8oxZ3hLfa2ZLv5n9VT4mHWald7hrfa25L9puYkd6WnOctT42E2WpWS/ayGMnelpDnLTSN5
 
 	"/index.html": {
 		local:   "site/adminator/build/index.html",
-		size:    6774,
-		modtime: 1531691819,
+		size:    10060,
+		modtime: 1792199368,
+		compressed: `
+H4sIAAAAAAAC/91abXPbuBH+KyjrSe0ZU1JySXqXyJpJ7Lz4movVSDdpP0IiJCLmWwBIipvJf++zAEiR
+EiXLca7TdiaRSWCBfcHug8WC/T9dXJ2P/zl8xWKTJoO+/f36lRmRFgk3ggUmLwLWGefF64TPNfv2rT/J
+oxs2TbjWZwEvCiZ1OM2ThBdaREFzcJLzSKiARkVy2ezTMhITjs6O7y7nLPhcYMbMcJlh8KDeFwuakGV8
+SUNbuhoDF0nZiQFhImYGjYkc9DmT0VkpQWjy+TwRQUm72RwrMTsLPvEl11MlC/NsmcvouHfyHJPJcpCR
+YSqyBZq6mL/L8Z8YdRcwapd0978pZCvH0LOVV2SGTebTcK7ETfiw1/OKkYxEc+5IrG3ljGWCdX5bN79S
+aiy+GBYEG3bkiVCG2d8w4tmcFkLlifA9YDJZGJNnzNwUaHQvlRWmSa6hfMQNDyOpU1lNGTCuJA8TPhHJ
+WXBu6QZ9XfDMdcQyikQGk6gFOh4YmQr9vN8lAljBsRkw6NKmBVToelcRWYRX1qr0ZTbLb9daguq/WOdK
+iS2l6wqpfMXmvAgf9VjKdZ6pG1bkOtzwft8VavkvxAfiMUyj8GlQul0LpUQo1glrJOSLqxgEzLKdIKz7
+8dPKSs5PfyE/fRFF7OPla3b8cnx+yt7Rzz8u3pycspeXw59+dn9+YbliXwq17Hfjp01RxuHDJ5h7lqsU
+MZ3SMkVRuJKzgKXCxDkCYHg1GjelI2qIkC8KCsCsWBg/lswL02rTgUgjo2DIwC+0gZmDxgQUd/CMwEaZ
+nQWD/DJHgiJ9IqLJzVkAad6KBCAI3JqKOE+AMmfBK6ygItVPsWRTdVMYEbFrccOOnw47nQ4soAVailhx
+LUoDBAPW1ylPEsu0mrguFgnK6CdMF4bw9H1uxDNmYmHtLDXTfImJTc7godcMaEJ9BWbwvAqFEVMvjja5
+Eh04InFtcYbDbDkEbc2YxGyVq+gAg9LIHUalrhbDDteaHOeFkXnGk5Mtw60H7zXehbBLoxl3DkkmOaXV
+IJs536xZLp+BrrZst9kNkbnLM8vA+nmnWd9eDLmJ7+iiblCbQeOoxZYXQsklJxtCTTA7Tru9pmOm3ceP
+/9LtuX8nwV4PKXV6vEenN7y4s0oYc7BGNH8iU2nY8aOavGvfKEftcQtWvAuBOldZcsMWyFkYiBiAnCKU
+fKNmn1OWIn4YQEkJrQX8CG8rbqYxSLgGIMsEbbAy+jLBnWMRhsOX6BHAvelFfgPatN1oMSG1yHhLnixa
++oC1BAFr82rbXOk6MRnD/7BQMuXqpgLnav/pd8kczXzkj90eRmSKpeRs9Nt4SLZ9O8bfF8PLQ7aC2SoK
+P2nang/cC+zuTKvpXWuo8iVyOfUe0wFI8cuOrfM8Yw8fsWnMFQdOKn2CXI2GAmTa/fr1KrKj7+bZDfZN
+N7azwUUKT9JYc8/NZwbBbai9S+shXFYg4ynf10pqkWCDaNPSjbF6HqabZ9ISvoXtscE46DsgL5UMBucL
+bEwp+3V09b7fdZ2U/CjKUtkRCXbKjow0iWDPzqxFOo6TPYA0Z8M4O8Lail7cOMqp1jP7tKrrVG+FckYu
+2Gu6V83/d3qGE+xv2PoPco+GHyASXNLAp1MAjH2+vLCANHo1asXjw0UaYfPzi3nQvt2QzA8+RrqafMID
+hwEbIu3cEvfY8fHtQl/k9oR0d1MS1MhsziI7wR7LHSDEBzEnB7u7EH7gsVgQ2L34OGLKtpzcT55x/l2y
+TGUh7bmxsWTrvbIWovvTqKGcXiM1KtHKOkMikIfaPY6imOFYb5BaVe9IVGcySbBNYjVnCsFOXd7fT/0q
+2YlUKeeBWeouvCO2weDSWtIBS4l3pAo2bt5mYCvsTryDO6MN3ryNfJZdK+65/pbkxUnoIZ5eqsNfKWEj
+zW3OtHeBrpZCrRTtyCuJPM8uhlsHnBOUSHMsFTIch4c/Kh+BEmOhze0JiV5YdPPpAI2pUpIH2UQXz90v
+u5cod8iL3JgdaVG9EDWm9CbeqMgAqoGhsOMGxHEVbbeErirl/IeeAVGUAyEQKGl6UqU/k7C3Lk5sCJ7I
+7NpXJVxNymKHLbiVzVzNhTkL/ly2lzycg4ovBSJNQIQZT2iQbfVertezXWWCIkgbtZiSp2i7E9FcIUWu
+i6rKavGTus1IwS3ua6Tz0q6rKInP8ps2ceoUiIWM1Nlj65DKkM1mwyeJCAFqBURHSs4KSipZ8QHLaFPU
+QamKSz8tfWMwqAxJhD+KHquViFbjsMdWdG7ATo+AjVu7H6P/g/i8t/9CuDKijUSi6xKvbsmX1PLsI1dh
+GsTGFCEvZFVPoh4TVZCAeclDP+zquBDIclOJI4qJOYAjBjjjHwChTMdx1AZ/tZI4EuJIZNP1Ezedla4h
+zkIl3yvJGHsAhhMqxRSBOxhoylb3crjax2HCtZwyvsAC0FQEJ7tYUUr0g1iV2dUuVg4Jvpvb1+BBYp5j
+83wwN8+DZ/bNoqF9/7ZbQQX1zX+eMfnxvSxLtwy0x9UYdH10dG2wth1kd+Smh4Mzef7dkJmtLz/uhtGW
+1d0AerzKWwDaHq4PBmfH9mBkduR/LCwTj/8tSNapKX48HNuFnFL+t4HH5b61E5N9heo+uOyn8Nh86jLJ
+IleGChWZoAwO2dP/PWbfDWPuUUSLWFVH27oCUeFsFe2reyX8BpDe0gaPRrpKJU6krjbEmE1jd5boyCKE
+Au7K4OPla+0icUun2vS3xfeaefC9IT0y3Cz03qg9z2W2l+BFGRQ7aEDyjuMAcyESiK1EtI/yPBbT653o
+sC5e2ePtUSQ1jn43tnpFYnQufANOe96ZqVRFS32eGSKTwP8v7MhRvxcrKrzY2dZ3oHOzHtGjZncBWQrL
+I/D318giC5/0WPn4V/IILBxLYqhjTDhlljoscEQPBuDm44nVSmU7soGvlXKd84V6MVnuJ8ZOlU3pvt+q
+8tMjUDtlqlne2LsF+q5AlcWJpps+3eRbjfAH2UrojZlHMTasyFXm9s7ObMkQ3kk3aYvJtbB1fcAQvMLW
+5KhD29ns64prFtHdCl0eqDwNBjXauqQNCTal3az6HPkjLXnAO1vksBp5H6iffav5S7KNQoPdxiutXN3D
+ZgpmjfOuVoPGFXZtiF5+mKGxAWV208GRG3o6Hq31EVZ/CXVKlT+q4R/gOhR3Vdhtr9xHLg0V88p1a3Xz
+3Eac83P/3HT0mo+Xtpjli8xf+Lh7nDCna6DKJkjEaMeD6q6QYl/DwtUWmyqsZcRM9tEH0S53/PsCuXgG
+QqvxHsVgEqcVPdyuEnwVM8KScian7raP6mrkpFluqiWO7M2fXfWaKOgmnynvq8jxI6mamjYFZ5/Xr5sa
+l0s/2LpjKL24hpRLqvFTJQvod2Rr/DWQ3K7xL62fO5uKz/ReCYjN0lCJlDmuIqoEchcCYNLxFzj7LgWq
+JKDx8YmFXE1CJiLbQvOaQI6u5yC+UUMKXDgglYe1zoInzf2z/sWKL5W54t5U0EcGGx+w/K7dGvZxCMiz
++fqSz78zf1LhEyr6wSwQC85ey+7SPJMmV2XusqX0ASlQI48ZlzmH0/Re9cT8DmW83wucSkTtzOME3HXH
+6X+p7tz8EG2W58Z/pFajbJBMcrBIA9Z5aR+qT+G63lD2s7l/A1WXMntMJwAA
+`,
+	},
+
+	"/keygen.html": {
+		local:   "site/adminator/build/keygen.html",
+		size:    2875,
+		modtime: 1792197159,
+		compressed: `
+H4sIAAAAAAAC/81Wy47bNhT9FVaLIgEiyzNIBm0iG0gmSRukTYLGQNElZdISO6QokJQdNci/91xKsiWP
+p+2yC1Pyfd9zH2L+3euPt5s/Pr1hVTB6ncfz61cWpGk0D5IlwTYJW2xs81bz0rNv3/LCio5tNfd+lfCm
+YcqnW6s1b7wUyVxZWy6kS0hLqP2c55WQBQdzMbBHmw0vJSzWgasayuspr5JkkNV8T6oXWDPFVo9MKKRa
+7gKIWq1zzpRYjRGkwZallskoe06unNytkj/5nvutU014vrdKPFo+fgFjalQKKjWybkHKYD/j+JGjrAWo
+GeU+nAaxjTr0HuOVdWBFuU1LJ7v0arkcEqMYSea2F4nYqh2rJVv8eiK/cW4jvwSWJGc4ci1dYPFMBa9L
+KoSzWg4cOCnaEGzNQteA2P85orDV1iN5wQNPhfJGHU0mjDvFU80LqVfJbZRb577hdc+olBCyBiSuBeP7
+oIz0L/KMBIBC72bNkMulLJBCNrSKrAX+sotJv6t39t+zVpD6H+d8TOJe0tOEnD2wkjfp9ZIZ7m3tOtZY
+n551/8BKvfoL84F5TI1Ib5Kx7S5IKoziVHAiQr14qCDAotsCY51XN0eU+j79kfr0J4lJo3H+IA/spRBO
+ei99nlU3c5+b9OoZjOysMxheA+jvZFdKQG9kqCwa/dPHz5t5FCScIvsL1NLZthmD/4GGmgrDwFslqm7a
+8D5a/yBRcRwH6+4wjySzZrmXWm7DEAaVBOXwYXFUAf7JzBuNKJooiQN5bh4GHA0XGwwsBn9xVdomKDTb
+nut28EXG18NLnvX8Sd2zPrgLZbuf99MH8761La2Ln9E5htcde6SVUeE5u14+PsEQ5R9AIRqIOPRzUrem
+oPVhFHr8Ck/+ZZVcL5Mxs6v/BtgQ12whesO1nmkHGgk6UtMGKZh5FZuHOgxN4zFwEgEIyWiMK+WBh4EL
+tD0HiByDKFiwYEl24GFbyUjsO5M5VVZYDwfePYkSv79721v0fA9BVUdqg2iaynGPV2cDKgIeOQ/WyQWq
+REEDw2GZnIP4uS2Ad8RvUvopr8djgrCP5COMRagZfmnjlOGuOw3acZnkGYE1x3JcItiXYze+Vh7f2+5s
+p1zaAVfXZ0tAsOMemHNgTzp/gcYO9PVizS+0NZrf4rnB+eD62ADrcshsUqV+f8w649QPvdaxbrbWHfOV
+PdR43con7OBocaGKhgmiAozOtmgFdDRnBd/eYYRsG+gzz+wu1vteae/P3yTBeeaBF1qmCLxBfdVeTtOH
+ZGTPZIlIlxU8HL2eAD9s0iVcoNlvrarzLFQX+U8h8AYXA/2gxDN4BkI9PyM32eiSbm/3l9akTWJQ4jiR
+cEcZxwZu3ctiH9dUEA8JxcD+QSbfWiGjJAKMcpHQi1Ook2XYB4snwXah1c/aflSbsuj+NL937iwGur+T
+ztVPIoXFiBncTF/Fl+PNNxviibfkvwGb9gNbOwsAAA==
+`,
+	},
+
+	"/outbox.html": {
+		local:   "site/adminator/build/outbox.html",
+		size:    2828,
+		modtime: 1792198350,
 		compressed: `
-H4sIAAAAAAAC/9xZbXPbuBH+K1vUk0lmQklOz+nUpjjjs+Oe24vtiXWT9iNErEScQYAHQGJUjf57Z8EX
-kbbklyTXafuFIwKLfcM+DxdQ/Ifz67PJP28+QOZzlcThuV6Dx7xQ3CMwbwoGg4kpLhSfO9hs4qkRK0gV
-d27MeFGAdFFqlOKFQ8H6i5XhAi2jVUIu+3NOCpxyy2BQTzc6Cz7HKDXac6nRsqQ7lyEpBM2XtHTHVG/h
-QjWTmi8jhTPPkljJJOYgxbjxIPJmPlfIGtn7w5nF2Zj9ypfcpVYW/nhppHg9enPCklg2i7yMctQLlsRD
-mcRDnsRDMjRcqCQeUuz1M+dSN2vod/AXtYfpPI3mFlfR4WhUB0Y+ksxZJRJyK2egEQYft8MfrJ3gFw+M
-3csjV2g9hGckuJ7TRlijsJ5hSTxdeG80+FWBY1a9tFlIlXHIQHDPIyFdLluVDLiVPFJ8imrMzoJcEruC
-62oik0KgHjNvF8iSV17m6E7iIQkk8bAyk8B6vTOKzaZK1HoNqAVsNrAz6Es9M09HLfXM/BfH3AbxIOhu
-QNaUMOdF9G4EOXdG2xUUxkX3qr+eipz8F1pIjYpyEb1nTdntkJQe865gR4RqscykRwhmp4Ilcfa+zVJV
-p3+hOj0VAj5fXsDrHydnb+Fnevzj/K9v4mH2vm90Eh0esSSeGZuD5jltiBBRKWcMcvSZEWN2c3076ftB
-0tHcmkVBUNPFwtdrKZFnRjs/+Hx5cestbDas3lKPXzzrKSCEWaNYwFPQ8vnyot5QgYTpKYrpasxKOfsJ
-VcGgUDzFzCiBdsw+aI+WgmQJxC7nSgVFrXDXFBkHekT5whMbXhmPx+AzDFmSDrTx4PgSBXgDQrq7QTwM
-Spudquvzfpy3i2kufYhzydVix9ypEGRjmwkXhlsHp17D1OuosDLndtXuXVue8ZBi6NPV71s9t1TtS8nh
-9uPkBoyFnyaTGzi9uXxO/cxKEf3qCL3PLKAAXpgZW1fBjTVLKdBe8RxZQk94rWQu/TEcvoM045anHq17
-Ew/D0gT21OBFKcLqlxVhz3y/5II2M4OiFunteW2tJo5dCH9W1H+7vb5iyWUIiH5vgyTnuUW+K06SDHHu
-jM2a0o3Z4WhHlMHcDsw1Ie4AXuVhHTK9tFzZeNgDZF/To6i8XqItLVVoKX0GmBd+FZJAoLSYmyUC12AK
-L43+Xvi8KMUEnX8aoG6RpuhcDQ9a00L0lZ664qR6wje58gKeqNbsoYlu3zYhuGf3GhiepsYKaXQfmSm3
-4uFIVDVxVf3Qb6nnxAmnN5dEIkctHUyj0fZbfs9xJfVd/RGvWrgxa/rTZpjbOfox+2Mz3tioChS/FFwL
-FGM244oWhdG6yt1W27VGQpDzdpFSpTiCWeCw6PTmskZVm7XsqJszCvCB9SYTrbfbpkNVcLmXkyqcglvU
-FM4juY6oa+8Pez5VGFl0hdFOLhEKIlkoPkXvRoGykyaUio6DfG8xS2JPHiWxt/Sz3QlRTqIRlNERS/6O
-q3jos53TPxyx5BP+9uj8OVZdd0AiyQ3J1rCxS2HV5kXVkCWZ90XEC9m2XzTjRUsJZfQDVeinfRPn6NHm
-Eh34jHvwmXT05ea6/TzBa+MztKV0CAuH4fP1plIXvOu5s7Dqaz2ZZAgLq4iVMkLgHgMO7RMWrh+zMOVO
-psAXPqNgLNHJPlMFd+47mSJVpbFin6mKCb7a2pq9Uv7kDlev5v6EHYe3wIbhfbM/QMtz2v//tGGq42/K
-LB3K6RvXMTCs0TEMYN3V2O1p8p5PzlT5L2Nm2N4VvIyjg6mXEfSkNDsIOjSbzybnyuyzmbkS/31pmWz8
-b1Gyy33x/ek4bGRK/d89Pm6+W3s5mQth8Ukqe5SXaxU1N7+tOsnCWE+Nu0bq4Lhd/d9z9ss45hsOlQLa
-c+WDewQbzUrx2DlQ8RVat2MMSmpXofiZWtcAMQht7N4jK2WEWABTj+H47CokPoipo/4pfG+Ns6+F9K3n
-fuEeRe2ZkfpRgdMGFHtkSEeG6d1ezK/XYLmeIxzc4eotHAjpCsVXcDyGASkfnNcDm01Tous1HNAGnmlP
-YlIL/AIHlfQVlh+5VEHb9iJw7rcrRjRc3cI1znIxx+YuFXV0NILm559pn6PDEagsGoH3UQpBOiqkUiy5
-wrJGCWyv4fZ949dtcIOzhT2dLh8X9nahU+6rxMCf3nWkk9ghFdP2FFeHyzrpXL6Fg3CIPR7DAZ2Gu5ms
-Tqmdo97BMhzuqnThb/TeejsrhTeUtcoqijbWEBQZGdR3GfGw0ty5lRxWq5It/nvXtGFfHDmpUD/Y8o5D
-ldyoqoPe8ZFVGTSKdmLMfuhDp3u3W5+Sq3N9ito/uN7+xWG4eYudt0bPt/dd9TvUTQqf0nnfG6J04F1i
-z42W3tiGth4E/Qz261HYpKGbKtJvukowLzjB/1II7rHT7lQO7rvuq585l7r/l83MGF//ndOR7IlMjfcm
-ZzD4Mfxo/zQa1okKfzD9OwAA//9inv8FdhoAAA==
+H4sIAAAAAAAC/81WW6/bNgz+K5wxDC0w2WmxdZcmwbpzwQa0PcWpX/YoR4qtTrYMiU7qFf3vI2U7iU+T
+nQF72UMUW/xIkx8v0vKr67ur/I93N1BhbdfLuH76BKjr1krUkKBrE0hz195aWQb4/HlZONXDxsoQVols
+WzBBbJy1sg1aJXNl66TSPmEtZXZzWTBKF5KE6SiebLay1GSxQWkaUl6fyirNBqGRO1Y9I5opdnYSkoKw
+eou0ac16KcGo1eSBQFeWVicT9uF25fV2lXyQOxk23rT4884Z9WTx9CUZM5MSGlHrpqOtjOxnkn78oawj
+UjOOfVxr8m3S4efor24QinIjSq978WyxGANjHxlzNUAit2YLjYb0zXH7xvtcf0RIkgc8Sqs9QlyFkk3J
+ifDO6lFCHyk6RNcA9i1tDi8HFjbWBQpeSZRCmVCbg8kEpDdSWFlou0quIm69DK1sBkFllNINUeI7EnyD
+ptbh5TJjALEwfGYNFMu5KCiEbCwV3Sh6hbNB/95s3eNRG0L9j2M+BPFF0KcBebeHUrbi+QJqGVzje2hd
+EA+qfxSJYP6i/qB+FLUSz54nU92dgRrqxRnyBFOoWJD7ikBziZW99uHMHuy5dKF9zZ6293HNaSVo9eLA
+8FDjP3GN33VYuI/LrHpBTNbSHpoViRNRd8jj5I0OgeZBAKwkpdVr2EuDpikBHRQats7vpVdapZDTaHFe
+Ej9baWznSYnxXqM3WsHeYAUSSuKT1dnMt1DPzLMeW4TSOQVP6AGdI9abHmJGn0JA2UOlyWzXIIGx0v3s
+K6RDlbMZXKIC4LjO5OCEsTmVKAurBfneuiaYnT7lk5BRPMPyJo8++vP8eMzgPhekckMTxC4zrM4J33fF
+B73BS+LbgVzI3SXEvd5o8lJdkr9CHvgYLsnfcvmPoEuY1zIg0Hxw/hJi2M+YgGwig08pbijPkw/SodjS
+axPo+Om5xSJdajK13YvvOBfcoq+U8oT4pe2KPzXTl25cTZbVJYWRxtjHl1G3e5W7RzATn4/AJlr/GUZj
+M70mNniKDrNq4k0q4oQb3Gslvl/A8PADty+VJNhKLABRbCAiRWusTdaKTI0jjSeZtkGP8zl9O0wxOA6w
+C14NjR5joIxG4NQiFxSoHWtqU6wcnYbv7t7nfOo2bYfjCB8mb0JXgpreyPDXKY3XgGPGf5Ohos8ksJO2
+GwDptLeG6TT4QpkyWht8oDgXDvbvqe/7iBvcCVF2OFEKbIB+ItTxr/WmpgHFfUNqx4Phv/txPQydf+3J
+eCFYj3oHV5YZEz6mgzvqmOnzHcMnCNfEKvkxWeeVBhf9oVshcIn26dzUVB2xPemfR9j8hvTIyhei+UVy
+6xyOl8wT5AxSOAqupqvmr/HhcJXNRjfitfdvSbUf1wwLAAA=
 `,
 	},
 
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/njones/bitcoin-crypto/bitelliptic"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// verifiedHeader is added to every message with the result of verifying it,
+// any copy of it sent with the message is replaced
+const verifiedHeader = "X-Pubkemail-Verified"
+
+// the results of verifying a downloaded message
+const (
+	verifyPass     = "pass"       // the content hash matches and pubkemail signed it
+	verifyUnsigned = "unsigned"   // the content hash matches but there's no signature
+	verifyNoHash   = "unverified" // there's no content hash to check, it's still forwarded
+	verifyFail     = "fail"       // the content hash or signature is bad, it's quarantined
+)
+
+// contentSignature is the outcome of checking the PGP signature of a
+// message, it's sent back from the agent along with the message
+type contentSignature struct {
+	Signed bool   `json:"signed,omitempty"`
+	Err    string `json:"err,omitempty"`
+}
+
+// errNoContentHash is returned by verifyContent when there is no hash, the
+// content wasn't checked rather than failed so it's not quarantined for it
+var errNoContentHash = errors.New("there is no content hash to verify")
+
+// verifyContent checks that the downloaded content is what the SHA-256
+// in the feed link is for, so the content server can't swap it
+func verifyContent(content []byte, sum string) error {
+	if sum == "" {
+		return errNoContentHash
+	}
+
+	got := sha256.Sum256(content)
+	if !strings.EqualFold(hex.EncodeToString(got[:]), sum) {
+		return fmt.Errorf("the content hash does not match")
+	}
+	return nil
+}
+
+// newServerEntity returns the PGP entity that pubkemail signs the messages
+// with, it's the pubkemail shared public key as an ECDSA key created at the
+// timestamp of the message. It only has the public key so it can only verify.
+func newServerEntity(serverKey []byte, ts time.Time) (*openpgp.Entity, error) {
+	x, y, err := unmarshalPoint(serverKey)
+	if err != nil {
+		return nil, fmt.Errorf("server signing key: %v", err)
+	}
+
+	pubKey := &ecdsa.PublicKey{Curve: bitelliptic.S256(), X: x, Y: y}
+	return &openpgp.Entity{PrimaryKey: packet.NewECDSAPublicKey(ts, pubKey)}, nil
+}
+
+// messageSignature returns the outcome of the signature check, it
+// must be called after the message body has been read to the end
+func messageSignature(md *openpgp.MessageDetails) (sig contentSignature) {
+	if !md.IsSigned {
+		return sig
+	}
+
+	sig.Signed = true
+	switch {
+	case md.SignedBy == nil:
+		sig.Err = fmt.Sprintf("signed by an unknown key %X", md.SignedByKeyId)
+	case md.SignatureError != nil:
+		sig.Err = md.SignatureError.Error()
+	}
+	return sig
+}
+
+// verify records the result of verifying the message on it and
+// in the X-Pubkemail-Verified header
+func (msg *Message) verify(hashErr error, sig contentSignature) {
+	var err error
+	switch {
+	case hashErr != nil && hashErr != errNoContentHash:
+		err = hashErr
+	case sig.Err != "":
+		err = fmt.Errorf("the signature is invalid: %s", sig.Err)
+	}
+
+	value := verifyUnsigned
+	switch {
+	case err != nil:
+		msg.Verified = verifyFail
+		value = fmt.Sprintf("%s (%v)", verifyFail, err)
+	case hashErr == errNoContentHash:
+		msg.Verified = verifyNoHash
+		value = fmt.Sprintf("%s (%v)", verifyNoHash, hashErr)
+	case sig.Signed:
+		msg.Verified, value = verifyPass, verifyPass
+	default:
+		msg.Verified = verifyUnsigned
+	}
+
+	if msg.Header == nil {
+		msg.Header = make(map[string][]string)
+	}
+	msg.Header[verifiedHeader] = []string{value}
+}
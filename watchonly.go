@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/njones/base58"
+	flag "github.com/spf13/pflag"
+)
+
+// isWatchOnly returns true if the WIF only has the address and shared key,
+// mail can be found for it but not decrypted. A WIF held by an agent isn't
+// watch-only, the agent does the decrypting.
+func (w WIF) isWatchOnly() bool {
+	return len(w.priKey) == 0 && w.agent == nil
+}
+
+// watchOnlyKey returns the <address>:<hex shared key> that a watch-only
+// address is imported with
+func watchOnlyKey(w WIF) string {
+	return fmt.Sprintf("%s:%x", w.addr, w.sharedKey)
+}
+
+// isWatchOnlyKey returns true if the string looks like <address>:<hex shared key>
+func isWatchOnlyKey(s string) bool {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	b, err := hex.DecodeString(parts[1])
+	return err == nil && len(b) == 65
+}
+
+// decodeWatchOnly returns a watch-only WIF from <address>:<hex shared key>,
+// the currency is worked out from the address version byte
+func decodeWatchOnly(s string) (w WIF, err error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return w, fmt.Errorf("watch-only key is invalid")
+	}
+
+	if w.currency, err = addrCurrency(parts[0]); err != nil {
+		return w, fmt.Errorf("watch-only %v", err)
+	}
+
+	if w.sharedKey, err = hex.DecodeString(parts[1]); err != nil {
+		return w, fmt.Errorf("watch-only shared key: %v", err)
+	}
+
+	w.wif, w.addr = s, parts[0]
+	return w, nil
+}
+
+// addrCurrency returns the currency of an address from its version byte
+func addrCurrency(addr string) (string, error) {
+	b, err := base58.BitcoinEncoding.DecodeString(addr)
+	if err != nil || len(b) != 25 {
+		return "", fmt.Errorf("address is invalid")
+	}
+
+	for _, network := range wifNetworks {
+		if network.params.PubKeyHashAddrID == b[0] {
+			return network.currency, nil
+		}
+	}
+	return "", fmt.Errorf("address network 0x%X is not supported", b[0])
+}
+
+// watchKey is the watch-key subcommand, run on the machine that holds the
+// WIFs it prints the <address>:<hex shared key> to add to a watch-only client
+func watchKey(args []string) {
+	fs := flag.NewFlagSet("watch-key", flag.ExitOnError)
+	wifStrs := fs.StringSliceP("wif", "w", nil, "a WIF to print the watch-only key of, can be repeated")
+	serverKeysPath := fs.StringP("server-keys", "", "", "a PEM file or directory of pubkemail shared public keys, so they never need to be fetched")
+	apiShared := fs.StringP("api-shared", "", apiURL.shared, "the base URL of the pubkemail shared public keys")
+	fs.Parse(args)
+
+	apiURL.shared = strings.TrimSuffix(*apiShared, "/")
+	if *serverKeysPath != "" {
+		files, err := loadServerKeyFiles(*serverKeysPath)
+		if err != nil {
+			fmt.Printf("watch-key: %v\n", err)
+			os.Exit(1)
+		}
+		serverKeys.m.Lock()
+		serverKeys.files = files
+		serverKeys.m.Unlock()
+	}
+
+	for _, wifStr := range *wifStrs {
+		wif, err := unmarshalWIF(wifStr)
+		if err != nil {
+			fmt.Printf("watch-key: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(watchOnlyKey(wif))
+	}
+}
+
+// exportPending is the export-pending subcommand, it prints the messages that
+// are waiting for watch-only addresses as ledger lines. They are imported with
+// --import-pending on the machine that holds the WIFs.
+func exportPending(args []string) {
+	fs := flag.NewFlagSet("export-pending", flag.ExitOnError)
+	dataDir := fs.StringP("data-dir", "d", defaultDataDir(), "the directory that holds the ledger")
+	addr := fs.StringP("addr", "", "", "only export the messages for this address")
+	fs.Parse(args)
+
+	l, err := openLedger(filepath.Join(*dataDir, defaultLedgerName))
+	if err != nil {
+		fmt.Printf("export-pending: %v\n", err)
+		os.Exit(1)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, e := range l.pendingEntries() {
+		if *addr != "" && e.Addr != *addr {
+			continue
+		}
+		if err := enc.Encode(e); err != nil {
+			fmt.Fprintf(os.Stderr, "export-pending: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// importPending downloads, decrypts and forwards the messages that were
+// exported from a watch-only client. Only the addresses that this client
+// holds the WIF for are used, the rest are skipped.
+func (c *common) importPending() {
+	if c.pendingPath == "" {
+		return
+	}
+
+	f, err := os.Open(c.pendingPath)
+	if err != nil {
+		log.Warnf("import pending: %v", err)
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for ln := 1; scanner.Scan() && c.work.Err() == nil; ln++ {
+		var e ledgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Warnf("import pending line %d: %v", ln, err)
+			continue
+		}
+
+		data, ok := c.addrDataOf(e.Addr)
+		if !ok || data.wif.isWatchOnly() {
+			log.Warnf("import pending line %d: no WIF for %s", ln, e.Addr)
+			continue
+		}
+
+		entry, seen := c.ledger.get(e.Hash)
+		if seen && entry.Status == ledgerForwarded {
+			continue
+		}
+		if !seen {
+			err := c.ledger.pending(ledgerEntry{Addr: e.Addr, Hash: e.Hash, Sum: e.Sum, TS: e.TS})
+			log.OnErr(err).Warnf("ledger record: %v", err)
+		}
+
+		c.termDeliver(e.Addr, e.Hash, e.Sum, e.TS, c.afterDate(e.Addr))
+	}
+	if err := scanner.Err(); err != nil {
+		log.Warnf("import pending read: %v", err)
+	}
+}